@@ -20,6 +20,8 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"github.com/ignalina/shredder/common"
 	"github.com/ignalina/shredder/fixed2avro"
 	"os"
@@ -29,23 +31,76 @@ import (
 
 func main() {
 
-	if len(os.Args) != 8 {
+	if len(os.Args) > 1 && "copybook" == os.Args[1] {
+		copybookCommand(os.Args[2:])
+		return
+	}
+
+	encoding := flag.String("encoding", "utf8", "input encoding: utf8, iso8859-1, cp1252, cp037, cp1047 or cp1140")
+	terminator := flag.String("terminator", "crlf", "record terminator: crlf, lf or none for fixed length records")
+	descriptor := flag.String("descriptor", "", "variable length records (RECFM=VB): rdw when each record has a RDW, bdw when blocks also have a BDW")
+	headerLines := flag.Int("header-lines", 0, "records to skip at the start of the file")
+	headerPattern := flag.String("header-pattern", "", "regexp, leading records matching are skipped")
+	trailerLines := flag.Int("trailer-lines", 0, "records at the end of the file that are trailer")
+	trailerPattern := flag.String("trailer-pattern", `^\*{12}`, "regexp, the first record matching starts the trailer")
+	trailerCount := flag.String("trailer-count", "", "offset:len of the record count in the trailer, the run fails if it does not match")
+	trailerSum := flag.String("trailer-sum", "", "offset:len:column of a hash total in the trailer, the run fails if it does not match the column sum")
+	columns := flag.String("columns", "", "comma separated columns to output, the Avro schema is reduced to them")
+	where := flag.String("where", "", "condition on the columns, only rows where it is true are output, ie \"Status != 'D'\"")
+	delimiter := flag.String("delimiter", "", "field separator of delimited input, ie , ; or tab. Fixed width when empty")
+	quote := flag.String("quote", `"`, "quote character of delimited input, empty for none")
+	escape := flag.String("escape", "", "escape character of delimited input, quotes in quoted fields are doubled when empty")
+	header := flag.Bool("header", false, "the first record of delimited input names the fields")
+	compression := flag.String("compression", "auto", "input compression: auto (from the magic bytes), none, gzip, zstd, bzip2 or xz. Only BGZF gzip and zstd frames with their content size use all cores")
+	stream := flag.Bool("stream", false, "read the data file in blocks with constant memory, always on for - (stdin) and named pipes")
+	blockSize := flag.String("block-size", "64MB", "size of the blocks read when streaming")
+	memory := flag.String("memory", "1GB", "memory ceiling on the blocks in flight when streaming")
+	verbose := flag.Bool("verbose", false, "log the output schema of -columns and the record types -where does not filter")
+	disk := flag.String("disk", "slow", "slow reads the data file into memory, fast maps it and parses from the mapped pages")
+	flag.Parse()
+
+	// Positional arguments keep their old index, flags are stripped away
+	args := append([]string{os.Args[0]}, flag.Args()...)
+
+	if len(args) != 8 {
 		println("Shredder V1.0 2021-12-19 02:24")
-		println("Syntax       : shredder <http[s]://kafkabroker | /outputdir> <schemaregistry> <schema file url> <schema id> <topic> <cores=partitions> <data file> ")
-		println("example usage: shredder http://10.1.1.90:9092 10.1.1.90:8081 schema1.json 5 tableXYZ_q123 1 test.data")
+		println("Syntax       : shredder [options] <http[s]://kafkabroker | /outputdir> <schemaregistry> <schema file url> <schema id> <topic> <cores=partitions> <data file | -> ")
+		println("example usage: shredder -encoding cp037 http://10.1.1.90:9092 10.1.1.90:8081 schema1.json 5 tableXYZ_q123 1 test.data")
+		println("Syntax       : shredder copybook <copybook file> [01 level record name] > schema.json")
+		flag.PrintDefaults()
 		os.Exit(1)
 	}
 
-	schemaId, _ := strconv.Atoi(os.Args[4])
-	cores, _ := strconv.Atoi(os.Args[6])
-	fullPath_data := os.Args[7] //"test.last10"
+	schemaId, _ := strconv.Atoi(args[4])
+	cores, _ := strconv.Atoi(args[6])
+	fullPath_data := args[7] //"test.last10"
 
 	var fst = common.FixedSizeTable{
-		Args:           os.Args,
-		Schemaregistry: os.Args[2],
-		SchemaFilePath: os.Args[3],
+		Args:           args,
+		Schemaregistry: args[2],
+		SchemaFilePath: args[3],
 		Cores:          cores,
 		SchemaID:       schemaId,
+		Encoding:       *encoding,
+		Terminator:     *terminator,
+		Descriptor:     *descriptor,
+		HeaderLines:    *headerLines,
+		HeaderPattern:  *headerPattern,
+		TrailerLines:   *trailerLines,
+		TrailerPattern: *trailerPattern,
+		TrailerCount:   *trailerCount,
+		TrailerSum:     *trailerSum,
+		Columns:        *columns,
+		Where:          *where,
+		Delimiter:      *delimiter,
+		Quote:          *quote,
+		Escape:         *escape,
+		HeaderRow:      *header,
+		Compression:    *compression,
+		Stream:         *stream,
+		BlockSize:      *blockSize,
+		MemoryLimit:    *memory,
+		Verbose:        *verbose,
 	}
 
 	start := time.Now()
@@ -54,10 +109,41 @@ func main() {
 		Fst: &fst,
 	}
 
-	err := t.CreateFixedSizeTableFromSlowDisk(fullPath_data, os.Args)
+	var err error
+	if "fast" == *disk {
+		err = t.CreateFixedSizeTableFromFastDisk(fullPath_data, args)
+	} else if "slow" == *disk {
+		err = t.CreateFixedSizeTableFromSlowDisk(fullPath_data, args)
+	} else {
+		err = fmt.Errorf("unknown disk %s, use slow or fast", *disk)
+	}
 	if err != nil {
 		panic("Nooo we have failed" + err.Error())
 	}
 	fixed2avro.PrintPerfomance(time.Since(start), &fst)
 
 }
+
+// Prints the Avro schema, including the len attributes, generated from a COBOL copybook
+func copybookCommand(args []string) {
+	if len(args) < 1 || len(args) > 2 {
+		println("Syntax       : shredder copybook <copybook file> [01 level record name] > schema.json")
+		os.Exit(1)
+	}
+
+	copybook, err := common.ReadFileToString(args[0])
+	if err != nil {
+		panic("Could not read copybook " + err.Error())
+	}
+
+	recordName := ""
+	if 2 == len(args) {
+		recordName = args[1]
+	}
+
+	schemaAsString, err := common.CreateSchemaFromCopybook(copybook, recordName)
+	if err != nil {
+		panic("Could not convert copybook " + err.Error())
+	}
+	fmt.Println(schemaAsString)
+}
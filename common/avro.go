@@ -20,9 +20,14 @@
 package common
 
 import (
+	"encoding/json"
+	"fmt"
 	"github.com/hamba/avro"
 	"log"
+	"math/big"
 	"reflect"
+	"strings"
+	"time"
 )
 
 func CreateSchema(schemaAsString string) (*avro.Schema, error) {
@@ -38,19 +43,108 @@ func CreateSchema(schemaAsString string) (*avro.Schema, error) {
 func getGoTypeFromAvroType(columnType string) reflect.Type {
 
 	mapping := map[string]reflect.Type{
-		"boolean":          reflect.TypeOf(true),
-		"Bytes":            reflect.TypeOf([]byte("")),
-		"float":            reflect.TypeOf(float32(0)),
-		"double":           reflect.TypeOf(float64(0)),
-		"long":             reflect.TypeOf(int64(0)),
-		"int":              reflect.TypeOf(int32(0)),
-		"string":           reflect.TypeOf(string("")),
-		"date":             reflect.TypeOf(int32(0)),
-		"time-millis":      reflect.TypeOf(int32(0)),
-		"time-micros":      reflect.TypeOf(int64(0)),
-		"timestamp-millis": reflect.TypeOf(int64(0)),
-		"timestamp-micros": reflect.TypeOf(int64(0)),
+		"boolean":                reflect.TypeOf(true),
+		"bytes":                  reflect.TypeOf([]byte("")),
+		"float":                  reflect.TypeOf(float32(0)),
+		"double":                 reflect.TypeOf(float64(0)),
+		"long":                   reflect.TypeOf(int64(0)),
+		"int":                    reflect.TypeOf(int32(0)),
+		"string":                 reflect.TypeOf(string("")),
+		"date":                   reflect.TypeOf(int32(0)),
+		"time-millis":            reflect.TypeOf(int32(0)),
+		"time-micros":            reflect.TypeOf(time.Duration(0)), // The codec writes a Duration as micros
+		"timestamp-millis":       reflect.TypeOf(int64(0)),
+		"timestamp-micros":       reflect.TypeOf(int64(0)),
+		"local-timestamp-millis": reflect.TypeOf(int64(0)),
+		"local-timestamp-micros": reflect.TypeOf(int64(0)),
+		"decimal":                reflect.TypeOf(&big.Rat{}),
+		"enum":                   reflect.TypeOf(string("")), // The codec writes the index of the symbol
+		"uuid":                   reflect.TypeOf(string("")),
 	}
 
 	return mapping[columnType]
 }
+
+// ProjectColumns marks the fields of a schema that are not in columns with "skip", they are then read by position but not output.
+// Empty columns keeps all fields.
+func ProjectColumns(schemaAsString string, columns []string) (string, error) {
+	if 0 == len(columns) {
+		return schemaAsString, nil
+	}
+	return editSchemaFields(schemaAsString, func(name string, fieldType map[string]interface{}) bool {
+		for _, c := range columns {
+			if strings.EqualFold(c, name) {
+				return true
+			}
+		}
+		fieldType["skip"] = true
+		return true
+	})
+}
+
+// OutputSchema is the Avro schema without the filler and skipped fields, also in nested records
+func OutputSchema(schemaAsString string) (string, error) {
+	return editSchemaFields(schemaAsString, func(name string, fieldType map[string]interface{}) bool {
+		return keepField(fieldType)
+	})
+}
+
+// keepField is false for filler and skipped fields, the kept fields have their nested skipped fields removed
+func keepField(fieldType map[string]interface{}) bool {
+	if true == fieldType["filler"] || true == fieldType["skip"] {
+		return false
+	}
+
+	switch fieldType["type"] {
+	case "record":
+		fields, _ := fieldType["fields"].([]interface{})
+		kept := make([]interface{}, 0, len(fields))
+		for _, f := range fields {
+			field, _ := f.(map[string]interface{})
+			nestedType, _ := columnTypeOf(field["type"])
+			if nil == nestedType || keepField(nestedType) {
+				kept = append(kept, f)
+			}
+		}
+		fieldType["fields"] = kept
+	case "array":
+		if items, _ := columnTypeOf(fieldType["items"]); nil != items {
+			return keepField(items)
+		}
+	}
+	return true
+}
+
+// editSchemaFields calls keep for each field with the type object of the field, fields where it returns false are removed
+func editSchemaFields(schemaAsString string, keep func(name string, fieldType map[string]interface{}) bool) (string, error) {
+	var schema map[string]interface{}
+
+	err := json.Unmarshal([]byte(schemaAsString), &schema)
+	if nil != err {
+		return "", err
+	}
+	fields, ok := schema["fields"].([]interface{})
+	if !ok {
+		return "", fmt.Errorf("schema has no fields")
+	}
+
+	kept := make([]interface{}, 0, len(fields))
+	for _, f := range fields {
+		field, ok := f.(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("schema field %v is not an object", f)
+		}
+		name, _ := field["name"].(string)
+		fieldType, _ := columnTypeOf(field["type"])
+		if nil == fieldType {
+			return "", fmt.Errorf("column %s needs a type with len, or a union of null and a type with len", name)
+		}
+		if keep(name, fieldType) {
+			kept = append(kept, field)
+		}
+	}
+	schema["fields"] = kept
+
+	edited, err := json.Marshal(schema)
+	return string(edited), err
+}
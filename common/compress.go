@@ -0,0 +1,324 @@
+/*
+ * MIT No Attribution
+ *
+ * Copyright 2021 Rickard Lundin (rickard@ignalina.dk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package common
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+const (
+	bgzfMaxBlock = 64 << 10  // Uncompressed bytes of a BGZF member
+	zstdMaxBlock = 128 << 10 // Decompressed bytes of a zstd block
+)
+
+var compressionMagic = []struct {
+	compression string
+	magic       []byte
+}{
+	{"gzip", []byte{0x1f, 0x8b, 0x08}},
+	{"zstd", []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{"xz", []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	{"bzip2", []byte("BZh")},
+}
+
+var compressionExtensions = map[string]string{
+	".gz":   "gzip",
+	".gzip": "gzip",
+	".bgz":  "gzip",
+	".zst":  "zstd",
+	".zstd": "zstd",
+	".bz2":  "bzip2",
+	".xz":   "xz",
+}
+
+// DetectCompression returns gzip, zstd, bzip2 or xz from the magic bytes at the start of the file, empty when not compressed.
+// A compressed extension on a file without the magic bytes is an error.
+func DetectCompression(fileName string, head []byte) (string, error) {
+	for _, cm := range compressionMagic {
+		if bytes.HasPrefix(head, cm.magic) {
+			// bzip2 is BZh, the block size 1-9 and the 48 bit magic of a block or of the end of stream
+			if "bzip2" == cm.compression && (len(head) < 10 || head[3] < '1' || head[3] > '9' ||
+				(!bytes.Equal(head[4:10], []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59}) && !bytes.Equal(head[4:10], []byte{0x17, 0x72, 0x45, 0x38, 0x50, 0x90}))) {
+				continue
+			}
+			return cm.compression, nil
+		}
+	}
+	if compression, ok := compressionExtensions[strings.ToLower(filepath.Ext(fileName))]; ok {
+		return "", fmt.Errorf("%s is not %s", fileName, compression)
+	}
+	return "", nil
+}
+
+// Decompress decompresses a whole file. Blocked gzip (BGZF, bgzip) and zstd frames with their content size, ie from
+// pzstd or zstd -T with --content-size, are decoded in parallel by cores goroutines, other files in one stream.
+// Other gzip files of several members, ie concatenated gzip files, are one stream, the end of a member is only
+// found by inflating it.
+func Decompress(compression string, data []byte, cores int) ([]byte, error) {
+	switch compression {
+	case "gzip":
+		if members := gzipMembers(data); nil != members {
+			return decompressParallel(members, cores, inflateMember)
+		}
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if nil != err {
+			return nil, err
+		}
+		return io.ReadAll(r)
+	case "zstd":
+		if frames := zstdFrames(data); nil != frames {
+			return decompressParallel(frames, cores, decodeZstdFrame)
+		}
+		r, err := zstd.NewReader(bytes.NewReader(data), zstd.WithDecoderConcurrency(cores))
+		if nil != err {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	case "bzip2":
+		return io.ReadAll(bzip2.NewReader(bytes.NewReader(data)))
+	case "xz":
+		r, err := xz.NewReader(bytes.NewReader(data))
+		if nil != err {
+			return nil, err
+		}
+		return io.ReadAll(r)
+	}
+	return nil, fmt.Errorf("unknown compression %s", compression)
+}
+
+// DecompressReader decompresses a stream, ie stdin or a named pipe, without reading all of it first.
+// Close releases the decoder, the zstd decoder has goroutines of its own.
+func DecompressReader(compression string, r io.Reader, cores int) (io.ReadCloser, error) {
+	switch compression {
+	case "gzip":
+		return gzip.NewReader(r)
+	case "zstd":
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(cores))
+		if nil != err {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	case "bzip2":
+		return io.NopCloser(bzip2.NewReader(r)), nil
+	case "xz":
+		x, err := xz.NewReader(r)
+		if nil != err {
+			return nil, err
+		}
+		return io.NopCloser(x), nil
+	}
+	return nil, fmt.Errorf("unknown compression %s", compression)
+}
+
+// compressedPart is a gzip member or zstd frame that decodes to size bytes
+type compressedPart struct {
+	data []byte
+	size int
+}
+
+// decompressParallel decodes the parts into their place in the output, the next part goes to the next free goroutine
+func decompressParallel(parts []compressedPart, cores int, decode func(part []byte, out []byte) error) ([]byte, error) {
+	offsets := make([]int, len(parts)+1)
+	for i, p := range parts {
+		offsets[i+1] = offsets[i] + p.size
+	}
+	out := make([]byte, offsets[len(parts)])
+
+	var mu sync.Mutex
+	var firstErr error
+	next := 0
+	var wg sync.WaitGroup
+	for w := 0; w < cores; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				mu.Lock()
+				i := next
+				next++
+				if i >= len(parts) || nil != firstErr {
+					mu.Unlock()
+					return
+				}
+				mu.Unlock()
+
+				err := decode(parts[i].data, out[offsets[i]:offsets[i+1]])
+				if nil != err {
+					mu.Lock()
+					firstErr = err
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return out, firstErr
+}
+
+// gzipMembers returns the members of a blocked gzip file, where the BC extra field of each member has its length.
+// nil for other gzip files, their members are only found by inflating them, and for members that claim to inflate
+// to more than the 64 KiB of a BGZF block.
+func gzipMembers(data []byte) []compressedPart {
+	var members []compressedPart
+	for off := 0; off < len(data); {
+		h := data[off:]
+		if len(h) < 18 || 0x1f != h[0] || 0x8b != h[1] || 8 != h[2] || 4 != h[3] {
+			return nil
+		}
+		xlen := int(binary.LittleEndian.Uint16(h[10:12]))
+		if 6 != xlen || 'B' != h[12] || 'C' != h[13] || 2 != binary.LittleEndian.Uint16(h[14:16]) {
+			return nil
+		}
+		blockSize := int(binary.LittleEndian.Uint16(h[16:18])) + 1
+		if blockSize > len(h) || blockSize < 26 {
+			return nil
+		}
+		member := h[:blockSize]
+		size := int(binary.LittleEndian.Uint32(member[blockSize-4:]))
+		if size > bgzfMaxBlock {
+			return nil
+		}
+		members = append(members, compressedPart{data: member, size: size})
+		off += blockSize
+	}
+	return members
+}
+
+// inflateMember inflates a BGZF member, its header is 18 bytes and the trailer is the CRC-32 and size
+func inflateMember(member []byte, out []byte) error {
+	r := flate.NewReader(bytes.NewReader(member[18 : len(member)-8]))
+	defer r.Close()
+	_, err := io.ReadFull(r, out)
+	if nil != err {
+		return err
+	}
+	if crc32.ChecksumIEEE(out) != binary.LittleEndian.Uint32(member[len(member)-8:]) {
+		return fmt.Errorf("gzip: checksum error")
+	}
+	return nil
+}
+
+// zstdFrames walks the block headers of the frames, skippable frames are left out. nil when a frame does not
+// have its content size or the file is damaged, it is then decoded as one stream. The output is allocated from
+// the content sizes, so a frame may not claim more than its blocks can hold.
+func zstdFrames(data []byte) []compressedPart {
+	var frames []compressedPart
+	for off := 0; off < len(data); {
+		h := data[off:]
+		if len(h) < 8 {
+			return nil
+		}
+		magic := binary.LittleEndian.Uint32(h)
+		if 0x184D2A50 == magic&0xFFFFFFF0 {
+			off += 8 + int(binary.LittleEndian.Uint32(h[4:8]))
+			continue
+		}
+		if 0xFD2FB528 != magic {
+			return nil
+		}
+
+		fhd := h[4]
+		singleSegment := 0 != fhd&0x20
+		pos := 5
+		if !singleSegment {
+			pos++ // Window descriptor
+		}
+		pos += []int{0, 1, 2, 4}[fhd&3] // Dictionary id
+		fcsSize := []int{0, 2, 4, 8}[fhd>>6]
+		if 0 == fcsSize && singleSegment {
+			fcsSize = 1
+		}
+		if 0 == fcsSize || pos+fcsSize > len(h) {
+			return nil
+		}
+		var size uint64
+		for i := fcsSize - 1; i >= 0; i-- {
+			size = size<<8 | uint64(h[pos+i])
+		}
+		if 2 == fcsSize {
+			size += 256
+		}
+		pos += fcsSize
+
+		blocks := uint64(0)
+		for last := false; !last; {
+			if pos+3 > len(h) {
+				return nil
+			}
+			bh := uint32(h[pos]) | uint32(h[pos+1])<<8 | uint32(h[pos+2])<<16
+			last = 0 != bh&1
+			blockSize := int(bh >> 3)
+			switch (bh >> 1) & 3 {
+			case 1:
+				blockSize = 1 // RLE, one byte repeated
+			case 3:
+				return nil
+			}
+			pos += 3 + blockSize
+			blocks++
+		}
+		if size > blocks*zstdMaxBlock {
+			return nil
+		}
+		if 0 != fhd&0x04 {
+			pos += 4 // Content checksum
+		}
+		if pos > len(h) {
+			return nil
+		}
+		frames = append(frames, compressedPart{data: h[:pos], size: int(size)})
+		off += pos
+	}
+	return frames
+}
+
+// Frame decoders are reused between frames, one per goroutine at a time
+var zstdDecoders = sync.Pool{New: func() interface{} {
+	d, _ := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+	return d
+}}
+
+// decodeZstdFrame decodes one frame into out, which has the room of its content size
+func decodeZstdFrame(frame []byte, out []byte) error {
+	d := zstdDecoders.Get().(*zstd.Decoder)
+	defer zstdDecoders.Put(d)
+	decoded, err := d.DecodeAll(frame, out[:0])
+	if nil != err {
+		return err
+	}
+	if len(decoded) != len(out) || (0 != len(out) && &decoded[0] != &out[0]) {
+		return fmt.Errorf("zstd: frame is not its content size")
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+/*
+ * MIT No Attribution
+ *
+ * Copyright 2021 Rickard Lundin (rickard@ignalina.dk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package common
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+// zstdRawFrame is a single segment zstd frame of one raw block that claims contentSize bytes
+func zstdRawFrame(content string, contentSize uint32) []byte {
+	frame := []byte{0x28, 0xb5, 0x2f, 0xfd, 0xa0, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(frame[5:], contentSize)
+	bh := uint32(len(content))<<3 | 1
+	frame = append(frame, byte(bh), byte(bh>>8), byte(bh>>16))
+	return append(frame, content...)
+}
+
+// bgzfMember is a BGZF member of content, isize overrides the size in the trailer when not 0
+func bgzfMember(t *testing.T, content string, isize uint32) []byte {
+	var b bytes.Buffer
+	w, err := gzip.NewWriterLevel(&b, gzip.BestCompression)
+	if nil != err {
+		t.Fatal(err)
+	}
+	w.Extra = []byte{'B', 'C', 2, 0, 0, 0}
+	w.Write([]byte(content))
+	w.Close()
+	member := b.Bytes()
+	binary.LittleEndian.PutUint16(member[16:18], uint16(len(member)-1))
+	if 0 != isize {
+		binary.LittleEndian.PutUint32(member[len(member)-4:], isize)
+	}
+	return member
+}
+
+func gzipped(t *testing.T, content string) []byte {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	w.Write([]byte(content))
+	w.Close()
+	return b.Bytes()
+}
+
+func TestDecompress(t *testing.T) {
+	tests := []struct {
+		name        string
+		compression string
+		data        []byte
+		want        string
+	}{
+		{"zstd frames", "zstd", append(zstdRawFrame("hello ", 6), zstdRawFrame("world", 5)...), "hello world"},
+		{"bgzf members", "gzip", append(bgzfMember(t, "hello ", 0), bgzfMember(t, "world", 0)...), "hello world"},
+		{"gzip members", "gzip", append(gzipped(t, "hello "), gzipped(t, "world")...), "hello world"},
+	}
+	for _, tt := range tests {
+		got, err := Decompress(tt.compression, tt.data, 2)
+		if nil != err || tt.want != string(got) {
+			t.Errorf("%s: %q %v, want %q", tt.name, got, err, tt.want)
+		}
+
+		r, err := DecompressReader(tt.compression, bytes.NewReader(tt.data), 2)
+		if nil != err {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		got, err = io.ReadAll(r)
+		r.Close()
+		if nil != err || tt.want != string(got) {
+			t.Errorf("%s stream: %q %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+// A content size larger than the blocks can hold is not used to allocate the output
+func TestDecompressContentSize(t *testing.T) {
+	if nil == zstdFrames(zstdRawFrame("hello", 5)) {
+		t.Error("zstd frame with its content size is not split")
+	}
+	if nil != zstdFrames(zstdRawFrame("hello", 1<<30)) {
+		t.Error("zstd frame of one block claiming 1 GiB is split")
+	}
+	if nil == gzipMembers(bgzfMember(t, "hello", 0)) {
+		t.Error("BGZF member is not split")
+	}
+	if nil != gzipMembers(bgzfMember(t, "hello", 1<<30)) {
+		t.Error("BGZF member claiming 1 GiB is split")
+	}
+
+	_, err := Decompress("zstd", zstdRawFrame("hello", 1<<30), 2)
+	if nil == err {
+		t.Error("zstd frame that is not its content size decompresses")
+	}
+}
@@ -0,0 +1,526 @@
+/*
+ * MIT No Attribution
+ *
+ * Copyright 2021 Rickard Lundin (rickard@ignalina.dk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// One data description entry from a COBOL copybook, groups have children and no picture.
+type copybookItem struct {
+	level     int
+	name      string
+	pic       string
+	usage     string
+	occurs    int
+	dependOn  string
+	redefines string
+	signLead  bool
+	signSep   bool
+	children  []*copybookItem
+}
+
+// Avro field in the same shape as the hand written schema files, ie the fixed width attributes sits on the type.
+type avroField struct {
+	Name string        `json:"name"`
+	Type avroFieldType `json:"type"`
+}
+
+type avroFieldType struct {
+	Type        string         `json:"type"`
+	LogicalType string         `json:"logicalType,omitempty"`
+	Name        string         `json:"name"`
+	Len         int            `json:"len,omitempty"`
+	Items       *avroFieldType `json:"items,omitempty"`
+	Fields      []avroField    `json:"fields,omitempty"`
+	Occurs      int            `json:"occurs,omitempty"`
+	DependingOn string         `json:"dependingOn,omitempty"`
+	Precision   int            `json:"precision,omitempty"`
+	Scale       int            `json:"scale,omitempty"`
+	Usage       string         `json:"usage,omitempty"`
+	Sign        string         `json:"sign,omitempty"`
+	Filler      bool           `json:"filler,omitempty"`
+}
+
+type avroRecord struct {
+	Type      string      `json:"type"`
+	Name      string      `json:"name"`
+	WidthUnit string      `json:"widthUnit"`
+	Fields    []avroField `json:"fields"`
+}
+
+// CreateRowFromCopybook parses a copybook into the fixed width layout together with the generated Avro schema.
+func CreateRowFromCopybook(copybook string, recordName string) (*FixedRow, string, error) {
+	schemaAsString, err := CreateSchemaFromCopybook(copybook, recordName)
+	if nil != err {
+		return nil, "", err
+	}
+	row, err := CreateRowFromSchema(schemaAsString)
+	return row, schemaAsString, err
+}
+
+// CreateSchemaFromCopybook generates an Avro schema with len attributes from the 01 level named recordName, or the first 01 level if empty.
+func CreateSchemaFromCopybook(copybook string, recordName string) (string, error) {
+	records, err := parseCopybook(copybook)
+	if nil != err {
+		return "", err
+	}
+	if 0 == len(records) {
+		return "", fmt.Errorf("copybook contains no record")
+	}
+
+	record := records[0]
+	if "" != recordName {
+		record = nil
+		for _, r := range records {
+			if strings.EqualFold(r.name, recordName) {
+				record = r
+			}
+		}
+		if nil == record {
+			return "", fmt.Errorf("record %s not found in copybook", recordName)
+		}
+	}
+
+	// Copybook lengths are storage bytes
+	ar := avroRecord{
+		Type:      "record",
+		Name:      avroName(record.name),
+		WidthUnit: "bytes",
+	}
+	names := map[string]int{}
+	fillers := 0
+	err = flattenCopybookItem(record, &ar.Fields, names, &fillers)
+	if nil != err {
+		return "", err
+	}
+
+	b, err := json.MarshalIndent(ar, "", "    ")
+	return string(b), err
+}
+
+func flattenCopybookItem(item *copybookItem, fields *[]avroField, names map[string]int, fillers *int) error {
+
+	for _, child := range item.children {
+		if "" != child.redefines {
+			log.Println("skipping", child.name, "redefines", child.redefines)
+			continue
+		}
+
+		// OCCURS is an array, of a record for groups
+		if child.occurs > 0 {
+			var items avroFieldType
+			if 0 != len(child.children) {
+				items = avroFieldType{Type: "record", Name: avroName(child.name), Fields: []avroField{}}
+				itemFillers := 0
+				err := flattenCopybookItem(child, &items.Fields, map[string]int{}, &itemFillers)
+				if nil != err {
+					return err
+				}
+			} else {
+				var err error
+				items, err = child.fieldType()
+				if nil != err {
+					return err
+				}
+			}
+
+			ft := avroFieldType{Type: "array", Items: &items, Occurs: child.occurs, Filler: strings.EqualFold(child.name, "FILLER")}
+			if "" != child.dependOn {
+				ft.DependingOn = avroName(child.dependOn)
+			}
+			name := addCopybookField(ft, avroName(child.name), fields, names, fillers)
+			if "record" != items.Type {
+				items.Name = name
+			}
+			continue
+		}
+
+		if 0 != len(child.children) {
+			err := flattenCopybookItem(child, fields, names, fillers)
+			if nil != err {
+				return err
+			}
+			continue
+		}
+
+		ft, err := child.fieldType()
+		if nil != err {
+			return err
+		}
+		addCopybookField(ft, avroName(child.name), fields, names, fillers)
+	}
+	return nil
+}
+
+// addCopybookField names fillers Filler_n and makes repeated names unique, the name is returned
+func addCopybookField(ft avroFieldType, name string, fields *[]avroField, names map[string]int, fillers *int) string {
+	if ft.Filler {
+		*fillers++
+		name = "Filler_" + strconv.Itoa(*fillers)
+	}
+	names[name]++
+	if names[name] > 1 {
+		name = name + "_" + strconv.Itoa(names[name])
+	}
+	ft.Name = name
+	*fields = append(*fields, avroField{Name: name, Type: ft})
+	return name
+}
+
+// fieldType maps picture and usage to an Avro type and the field width in bytes.
+func (item *copybookItem) fieldType() (avroFieldType, error) {
+	ft := avroFieldType{Usage: item.usage, Filler: strings.EqualFold(item.name, "FILLER")}
+
+	switch item.usage {
+	case "comp-1":
+		ft.Type, ft.Len = "float", 4
+		return ft, nil
+	case "comp-2":
+		ft.Type, ft.Len = "double", 8
+		return ft, nil
+	}
+
+	if "" == item.pic {
+		return ft, fmt.Errorf("elementary item %s has no PIC clause", item.name)
+	}
+	pic, err := parsePicture(item.pic)
+	if nil != err {
+		return ft, fmt.Errorf("%s: %s", item.name, err.Error())
+	}
+
+	if !pic.numeric {
+		if "display" != item.usage {
+			return ft, fmt.Errorf("%s: usage %s needs a numeric picture", item.name, item.usage)
+		}
+		ft.Type, ft.Len, ft.Usage = "string", pic.length, ""
+		return ft, nil
+	}
+
+	switch item.usage {
+	case "display":
+		ft.Len = pic.length
+		ft.Usage = ""
+		if pic.signed {
+			ft.Sign = "trailing"
+			if item.signLead {
+				ft.Sign = "leading"
+			}
+			if item.signSep {
+				ft.Sign += "-separate"
+				ft.Len++
+			}
+		}
+	case "comp-3":
+		ft.Len = pic.digits/2 + 1
+	case "comp":
+		switch {
+		case pic.digits <= 4:
+			ft.Len = 2
+		case pic.digits <= 9:
+			ft.Len = 4
+		default:
+			ft.Len = 8
+		}
+		if pic.signed {
+			ft.Sign = "signed"
+		}
+	}
+
+	switch {
+	case pic.scale > 0:
+		ft.Type = "bytes"
+		ft.LogicalType = "decimal"
+		ft.Precision = pic.digits
+		ft.Scale = pic.scale
+	case pic.digits <= 9:
+		ft.Type = "int"
+	default:
+		ft.Type = "long"
+	}
+	return ft, nil
+}
+
+type picture struct {
+	numeric bool
+	signed  bool
+	length  int // display length in characters, sign not included
+	digits  int
+	scale   int
+}
+
+// parsePicture expands repeat factors like 9(5) and counts digits, scale and the display width.
+func parsePicture(pic string) (picture, error) {
+	var p picture
+	p.numeric = true
+	afterV := false
+	pic = strings.ToUpper(pic)
+
+	for i := 0; i < len(pic); i++ {
+		c := pic[i]
+		n := 1
+		if i+1 < len(pic) && pic[i+1] == '(' {
+			end := strings.IndexByte(pic[i:], ')')
+			if end < 0 {
+				return p, fmt.Errorf("unbalanced parentheses in PIC %s", pic)
+			}
+			var err error
+			n, err = strconv.Atoi(pic[i+2 : i+end])
+			if nil != err {
+				return p, fmt.Errorf("bad repeat factor in PIC %s", pic)
+			}
+			i += end
+		}
+
+		switch c {
+		case 'S':
+			p.signed = true
+		case 'V':
+			afterV = true
+		case 'P':
+			// scaling position, occupies no storage
+		case '9':
+			p.digits += n
+			p.length += n
+			if afterV {
+				p.scale += n
+			}
+		case 'X', 'A':
+			p.numeric = false
+			p.length += n
+		default:
+			// numeric edited, ie Z,*,+,-,.,CR,DB. Lands as text
+			p.numeric = false
+			p.length += n
+		}
+	}
+	return p, nil
+}
+
+// parseCopybook returns the 01 levels with their subordinate items.
+func parseCopybook(copybook string) ([]*copybookItem, error) {
+	var records []*copybookItem
+	var stack []*copybookItem
+
+	for _, statement := range copybookStatements(copybook) {
+		item, err := parseCopybookStatement(statement)
+		if nil != err {
+			return nil, err
+		}
+		if nil == item {
+			continue
+		}
+
+		if 1 == item.level || 77 == item.level {
+			records = append(records, item)
+			stack = []*copybookItem{item}
+			continue
+		}
+		for len(stack) > 0 && stack[len(stack)-1].level >= item.level {
+			stack = stack[:len(stack)-1]
+		}
+		if 0 == len(stack) {
+			return nil, fmt.Errorf("level %02d %s outside of a record", item.level, item.name)
+		}
+		parent := stack[len(stack)-1]
+		parent.children = append(parent.children, item)
+		stack = append(stack, item)
+	}
+	return records, nil
+}
+
+// copybookStatements strips sequence and comment areas and splits the source into period terminated statements.
+func copybookStatements(copybook string) [][]string {
+	var statements [][]string
+	var current []string
+
+	for _, line := range strings.Split(strings.ReplaceAll(copybook, "\r\n", "\n"), "\n") {
+		if len(line) >= 7 && strings.ContainsRune(" *-/", rune(line[6])) && isSequenceArea(line[:6]) {
+			if '*' == line[6] || '/' == line[6] {
+				continue
+			}
+			line = line[7:]
+			if len(line) > 65 {
+				line = line[:65]
+			}
+		}
+		if i := strings.Index(line, "*>"); i >= 0 {
+			line = line[:i]
+		}
+
+		for _, token := range tokenizeCopybookLine(line) {
+			if strings.HasSuffix(token, ".") && !strings.HasPrefix(token, "'") && !strings.HasPrefix(token, "\"") {
+				if t := strings.TrimSuffix(token, "."); "" != t {
+					current = append(current, t)
+				}
+				statements = append(statements, current)
+				current = nil
+				continue
+			}
+			current = append(current, token)
+		}
+	}
+	if 0 != len(current) {
+		statements = append(statements, current)
+	}
+	return statements
+}
+
+func isSequenceArea(area string) bool {
+	for _, r := range area {
+		if !unicode.IsDigit(r) && ' ' != r {
+			return false
+		}
+	}
+	return true
+}
+
+// tokenizeCopybookLine splits on white space but keeps quoted literals together.
+func tokenizeCopybookLine(line string) []string {
+	var tokens []string
+	var quote rune
+	start := -1
+
+	for i, r := range line {
+		switch {
+		case 0 != quote:
+			if r == quote {
+				quote = 0
+			}
+		case '\'' == r || '"' == r:
+			quote = r
+			if start < 0 {
+				start = i
+			}
+		case unicode.IsSpace(r):
+			if start >= 0 {
+				tokens = append(tokens, line[start:i])
+				start = -1
+			}
+		default:
+			if start < 0 {
+				start = i
+			}
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, line[start:])
+	}
+	return tokens
+}
+
+// parseCopybookStatement returns nil for entries not taking storage, ie 66 and 88 levels.
+func parseCopybookStatement(tokens []string) (*copybookItem, error) {
+	if 0 == len(tokens) {
+		return nil, nil
+	}
+	level, err := strconv.Atoi(tokens[0])
+	if nil != err {
+		return nil, fmt.Errorf("expected level number, got %s", tokens[0])
+	}
+	if 66 == level || 88 == level {
+		return nil, nil
+	}
+
+	item := &copybookItem{level: level, name: "FILLER", usage: "display"}
+	i := 1
+	if i < len(tokens) && !isCopybookKeyword(tokens[i]) {
+		item.name = tokens[i]
+		i++
+	}
+
+	for ; i < len(tokens); i++ {
+		token := strings.ToUpper(tokens[i])
+		switch token {
+		case "PIC", "PICTURE":
+			i++
+			if i < len(tokens) && "IS" == strings.ToUpper(tokens[i]) {
+				i++
+			}
+			if i < len(tokens) {
+				item.pic = tokens[i]
+			}
+		case "REDEFINES":
+			i++
+			if i < len(tokens) {
+				item.redefines = tokens[i]
+			}
+		case "OCCURS":
+			i++
+			if i < len(tokens) {
+				item.occurs, _ = strconv.Atoi(tokens[i])
+			}
+			// OCCURS 1 TO 12 TIMES DEPENDING ON X
+			if i+2 < len(tokens) && "TO" == strings.ToUpper(tokens[i+1]) {
+				item.occurs, _ = strconv.Atoi(tokens[i+2])
+				i += 2
+			}
+		case "DEPENDING":
+			i++
+			if i < len(tokens) && "ON" == strings.ToUpper(tokens[i]) {
+				i++
+			}
+			if i < len(tokens) {
+				item.dependOn = tokens[i]
+			}
+		case "COMP-3", "COMPUTATIONAL-3", "PACKED-DECIMAL":
+			item.usage = "comp-3"
+		case "COMP", "COMPUTATIONAL", "COMP-4", "COMPUTATIONAL-4", "COMP-5", "COMPUTATIONAL-5", "BINARY":
+			item.usage = "comp"
+		case "COMP-1", "COMPUTATIONAL-1":
+			item.usage = "comp-1"
+		case "COMP-2", "COMPUTATIONAL-2":
+			item.usage = "comp-2"
+		case "LEADING":
+			item.signLead = true
+		case "SEPARATE":
+			item.signSep = true
+		case "VALUE", "VALUES":
+			// Initial values do not matter for parsing, skip the rest
+			i = len(tokens)
+		}
+	}
+	return item, nil
+}
+
+func isCopybookKeyword(token string) bool {
+	switch strings.ToUpper(token) {
+	case "PIC", "PICTURE", "REDEFINES", "OCCURS", "USAGE", "VALUE", "COMP", "COMP-3", "BINARY", "PACKED-DECIMAL":
+		return true
+	}
+	return false
+}
+
+// avroName turns CUST-NAME into Cust_name, column names need a capital first character.
+func avroName(cobolName string) string {
+	name := strings.ToLower(strings.ReplaceAll(cobolName, "-", "_"))
+	if "" == name {
+		return name
+	}
+	if unicode.IsDigit(rune(name[0])) {
+		name = "F" + name
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
@@ -0,0 +1,166 @@
+/*
+ * MIT No Attribution
+ *
+ * Copyright 2021 Rickard Lundin (rickard@ignalina.dk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package common
+
+import (
+	"testing"
+)
+
+// copybookColumn is what a test checks of a flattened fixed field
+type copybookColumn struct {
+	name      string
+	len       int
+	typ       string
+	usage     string
+	sign      string
+	scale     int
+	precision int
+	skip      bool
+}
+
+func TestCopybook(t *testing.T) {
+	tests := []struct {
+		name     string
+		copybook string
+		columns  []copybookColumn
+		arrays   []FixedArray // Occurs and DependingOn are checked
+	}{
+		{"display, sign, packed and binary",
+			`000100* CUSTOMER MASTER RECORD                                          CUSTMAST
+000200 01  CUSTOMER-RECORD.                                             CUSTMAST
+000300     05  CUST-ID            PIC 9(8).                             CUSTMAST
+           05  CUST-NAME          PIC X(20).
+           05  CUST-TYPE          PIC X.
+               88  CUST-PRIVATE   VALUE 'P'.
+           05  CUST-ALT REDEFINES CUST-TYPE PIC 9.
+           05  BALANCE            PIC S9(7)V99.
+           05  BALANCE-SEP        PIC S9(5) SIGN LEADING SEPARATE.
+           05  TRAILING-SEP       PIC S9(3) SIGN TRAILING SEPARATE.
+           05  PACKED-AMT         PIC S9(9)V99 COMP-3.
+           05  BIN-CNT            PIC S9(4) COMP.
+           05  BIN-BIG            PIC 9(12) BINARY.
+           05  FILLER             PIC X(3).
+           05  EDITED             PIC ZZ,ZZ9.99-.
+           05  FILLER             PIC X(2) VALUE SPACES.`,
+			[]copybookColumn{
+				{"Cust_id", 8, "int", "", "", 0, 0, false},
+				{"Cust_name", 20, "string", "", "", 0, 0, false},
+				{"Cust_type", 1, "string", "", "", 0, 0, false},
+				{"Balance", 9, "decimal", "", "trailing", 2, 9, false},
+				{"Balance_sep", 6, "int", "", "leading-separate", 0, 0, false},
+				{"Trailing_sep", 4, "int", "", "trailing-separate", 0, 0, false},
+				{"Packed_amt", 6, "decimal", "comp-3", "", 2, 11, false},
+				{"Bin_cnt", 2, "int", "comp", "signed", 0, 0, false},
+				{"Bin_big", 8, "long", "comp", "", 0, 0, false},
+				{"Filler_1", 3, "string", "", "", 0, 0, true},
+				{"Edited", 10, "string", "", "", 0, 0, false},
+				{"Filler_2", 2, "string", "", "", 0, 0, true},
+			},
+			nil,
+		},
+		{"occurs and occurs depending on",
+			`       01  ORDER-REC.
+           05  ORDER-ID        PIC 9(4).
+           05  MONTHS          PIC 9(2) OCCURS 3 TIMES.
+           05  LINE-CNT        PIC 9.
+           05  ORDER-LINE OCCURS 1 TO 2 TIMES DEPENDING ON LINE-CNT.
+               10  ITEM        PIC X(3).
+               10  FILLER      PIC X.
+               10  QTY         PIC S9(3)V9 COMP-3.`,
+			[]copybookColumn{
+				{"Order_id", 4, "int", "", "", 0, 0, false},
+				{"Months", 2, "int", "", "", 0, 0, false},
+				{"Months", 2, "int", "", "", 0, 0, false},
+				{"Months", 2, "int", "", "", 0, 0, false},
+				{"Line_cnt", 1, "int", "", "", 0, 0, false},
+				{"Item", 3, "string", "", "", 0, 0, false},
+				{"Filler_1", 1, "string", "", "", 0, 0, true},
+				{"Qty", 3, "decimal", "comp-3", "", 1, 4, false},
+				{"Item", 3, "string", "", "", 0, 0, false},
+				{"Filler_1", 1, "string", "", "", 0, 0, true},
+				{"Qty", 3, "decimal", "comp-3", "", 1, 4, false},
+			},
+			[]FixedArray{{Occurs: 3}, {Occurs: 2, DependingOn: "Line_cnt"}},
+		},
+		{"floats, groups and free format",
+			`01 REC. 05 GRP. 10 A PIC X(2). 10 B COMP-1. 05 C COMP-2.
+ 05 D PIC S9(11)V9(3) PACKED-DECIMAL. *> comment
+ 05 E PICTURE IS 9(3)P.`,
+			[]copybookColumn{
+				{"A", 2, "string", "", "", 0, 0, false},
+				{"B", 4, "float", "comp-1", "", 0, 0, false},
+				{"C", 8, "double", "comp-2", "", 0, 0, false},
+				{"D", 8, "decimal", "comp-3", "", 3, 14, false},
+				{"E", 3, "int", "", "", 0, 0, false},
+			},
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		row, _, err := CreateRowFromCopybook(tt.copybook, "")
+		if nil != err {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(tt.columns) != len(row.FixedField) {
+			t.Errorf("%s: %d columns, want %d", tt.name, len(row.FixedField), len(tt.columns))
+			continue
+		}
+		for i, want := range tt.columns {
+			ff := row.FixedField[i]
+			got := copybookColumn{ff.Name, ff.Len, ff.ColumnType, ff.Usage, ff.Sign, ff.Scale, ff.Precision, ff.Skip}
+			if got != want {
+				t.Errorf("%s: column %d is %+v, want %+v", tt.name, i, got, want)
+			}
+		}
+		if len(tt.arrays) != len(row.Arrays) {
+			t.Errorf("%s: %d arrays, want %d", tt.name, len(row.Arrays), len(tt.arrays))
+			continue
+		}
+		for i, want := range tt.arrays {
+			a := row.Arrays[i]
+			if a.Occurs != want.Occurs || a.DependingOn != want.DependingOn {
+				t.Errorf("%s: array %d occurs %d depending on %q, want %d %q", tt.name, i, a.Occurs, a.DependingOn, want.Occurs, want.DependingOn)
+			}
+		}
+	}
+}
+
+func TestCopybookErrors(t *testing.T) {
+	for _, tt := range []struct {
+		copybook string
+		record   string
+	}{
+		{"", ""},
+		{"01 REC. 05 A.", ""},
+		{"01 REC. 05 A PIC 9(3.", ""},
+		{"01 REC. 05 A PIC 9(X).", ""},
+		{"01 REC. 05 A PIC X(3) COMP-3.", ""},
+		{"05 A PIC X.", ""},
+		{"X REC.", ""},
+		{"01 REC. 05 A PIC X.", "OTHER"},
+	} {
+		_, err := CreateSchemaFromCopybook(tt.copybook, tt.record)
+		if nil == err {
+			t.Errorf("copybook %q record %q is not an error", tt.copybook, tt.record)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+/*
+ * MIT No Attribution
+ *
+ * Copyright 2021 Rickard Lundin (rickard@ignalina.dk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package common
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DateTime is the parsed fields of a date or timestamp, before any time zone is applied
+type DateTime struct {
+	Year       int
+	Month      int
+	Day        int // Day of year when Month is 1 and the format has DDD, time.Date normalizes it
+	Hour       int
+	Minute     int
+	Second     int
+	Nanosecond int
+	HasOffset  bool // The value carried its own UTC offset, ie ISO-8601 with Z or +01:00
+	Offset     int  // Seconds east of UTC
+}
+
+// EpochDays is the number of days since 1970-01-01, the Avro date
+func (dt DateTime) EpochDays() int64 {
+	return time.Date(dt.Year, time.Month(dt.Month), dt.Day, 0, 0, 0, 0, time.UTC).Unix() / 86400
+}
+
+// NanosOfDay is the time since midnight, the Avro time-millis and time-micros
+func (dt DateTime) NanosOfDay() int64 {
+	return int64((dt.Hour*60+dt.Minute)*60+dt.Second)*int64(time.Second) + int64(dt.Nanosecond)
+}
+
+// Local is the wall clock time as if it was UTC, the Avro local-timestamp-millis and local-timestamp-micros
+func (dt DateTime) Local() time.Time {
+	return time.Date(dt.Year, time.Month(dt.Month), dt.Day, dt.Hour, dt.Minute, dt.Second, dt.Nanosecond, time.UTC)
+}
+
+const (
+	dfYear4 = iota
+	dfYear2
+	dfCentury
+	dfMonth
+	dfDay
+	dfDayOfYear
+	dfHour
+	dfMinute
+	dfSecond
+	dfFraction
+	dfLiteral
+)
+
+type dateFormatField struct {
+	kind    int
+	offset  int
+	len     int
+	literal byte
+}
+
+// DateFormat is a compiled date pattern, ie yyyyMMdd, dd.MM.yyyy, yyyyMMddHHmmss, CyyDDD or iso8601.
+// Fixed width patterns are parsed by position without allocating.
+type DateFormat struct {
+	Pattern string
+	fields  []dateFormatField
+	width   int
+	iso     bool
+}
+
+// DefaultDateFormat is the DB2 style layout used when a date or timestamp column has no format, ie 2020-07-09-09.59.59.993750
+func DefaultDateFormat(columnType string) string {
+	switch columnType {
+	case "date":
+		return "yyyy-MM-dd"
+	case "time-millis", "time-micros":
+		return "HH.mm.ss"
+	case "timestamp-millis", "local-timestamp-millis":
+		return "yyyy-MM-dd-HH.mm.ss.SSS"
+	case "timestamp-micros", "local-timestamp-micros":
+		return "yyyy-MM-dd-HH.mm.ss.SSSSSS"
+	}
+	return ""
+}
+
+// CompileDateFormat compiles a pattern of yyyy, yy, C (century, 0 is 19xx and 1 is 20xx), MM, dd, DDD (day of year),
+// HH, mm, ss and S..S (fraction of second). Other characters, or letters in single quotes, must match as is.
+// The pattern iso8601 parses ISO-8601 dates and timestamps with optional fraction and offset.
+func CompileDateFormat(pattern string) (*DateFormat, error) {
+	df := &DateFormat{Pattern: pattern}
+
+	if "iso8601" == strings.ToLower(pattern) {
+		df.iso = true
+		return df, nil
+	}
+
+	for i := 0; i < len(pattern); {
+		c := pattern[i]
+		n := 1
+		for i+n < len(pattern) && pattern[i+n] == c {
+			n++
+		}
+
+		var kind int
+		switch {
+		case 'y' == c && 4 == n:
+			kind = dfYear4
+		case 'y' == c && 2 == n:
+			kind = dfYear2
+		case 'C' == c && 1 == n:
+			kind = dfCentury
+		case 'M' == c && 2 == n:
+			kind = dfMonth
+		case 'd' == c && 2 == n:
+			kind = dfDay
+		case 'D' == c && 3 == n:
+			kind = dfDayOfYear
+		case 'H' == c && 2 == n:
+			kind = dfHour
+		case 'm' == c && 2 == n:
+			kind = dfMinute
+		case 's' == c && 2 == n:
+			kind = dfSecond
+		case 'S' == c && n <= 9:
+			kind = dfFraction
+		case '\'' == c:
+			end := strings.IndexByte(pattern[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote in date format %s", pattern)
+			}
+			for _, l := range []byte(pattern[i+1 : i+1+end]) {
+				df.fields = append(df.fields, dateFormatField{kind: dfLiteral, offset: df.width, len: 1, literal: l})
+				df.width++
+			}
+			i += end + 2
+			continue
+		case (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+			return nil, fmt.Errorf("unknown letters %s in date format %s, quote literal letters", pattern[i:i+n], pattern)
+		default:
+			for j := 0; j < n; j++ {
+				df.fields = append(df.fields, dateFormatField{kind: dfLiteral, offset: df.width, len: 1, literal: c})
+				df.width++
+			}
+			i += n
+			continue
+		}
+
+		df.fields = append(df.fields, dateFormatField{kind: kind, offset: df.width, len: n})
+		df.width += n
+		i += n
+	}
+	return df, nil
+}
+
+// Parse cuts the fields out of value by position
+func (df *DateFormat) Parse(value string) (DateTime, error) {
+	dt := DateTime{Month: 1, Day: 1}
+
+	if df.iso {
+		return parseISO8601(value)
+	}
+	if len(value) < df.width {
+		return dt, fmt.Errorf("%s is shorter than date format %s", value, df.Pattern)
+	}
+
+	century := -1
+	year2 := -1
+	dayOfYear := false
+	for _, f := range df.fields {
+		if dfLiteral == f.kind {
+			if value[f.offset] != f.literal {
+				return dt, fmt.Errorf("%s does not match date format %s", value, df.Pattern)
+			}
+			continue
+		}
+
+		num := 0
+		for _, d := range []byte(value[f.offset : f.offset+f.len]) {
+			if d < '0' || d > '9' {
+				return dt, fmt.Errorf("%s does not match date format %s", value, df.Pattern)
+			}
+			num = num*10 + int(d-'0')
+		}
+
+		switch f.kind {
+		case dfYear4:
+			dt.Year = num
+		case dfYear2:
+			year2 = num
+		case dfCentury:
+			century = num
+		case dfMonth:
+			dt.Month = num
+		case dfDay:
+			dt.Day = num
+		case dfDayOfYear:
+			dt.Month = 1
+			dt.Day = num
+			dayOfYear = true
+		case dfHour:
+			dt.Hour = num
+		case dfMinute:
+			dt.Minute = num
+		case dfSecond:
+			dt.Second = num
+		case dfFraction:
+			for i := f.len; i < 9; i++ {
+				num *= 10
+			}
+			dt.Nanosecond = num
+		}
+	}
+
+	if year2 >= 0 {
+		switch {
+		case century >= 0:
+			dt.Year = 1900 + century*100 + year2
+		case year2 < 50:
+			dt.Year = 2000 + year2
+		default:
+			dt.Year = 1900 + year2
+		}
+	}
+
+	// Out of range fields would roll over into the next day or month, ie 2021-02-30 into March
+	maxDay := time.Date(dt.Year, time.Month(dt.Month)+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	if dayOfYear {
+		maxDay = time.Date(dt.Year, 12, 31, 0, 0, 0, 0, time.UTC).YearDay()
+	}
+	if dt.Month < 1 || dt.Month > 12 || dt.Day < 1 || dt.Day > maxDay || dt.Hour > 23 || dt.Minute > 59 || dt.Second > 59 {
+		return dt, fmt.Errorf("%s is not a valid date for format %s", value, df.Pattern)
+	}
+	return dt, nil
+}
+
+var isoLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999", "2006-01-02"}
+
+func parseISO8601(value string) (DateTime, error) {
+	value = strings.TrimSpace(value)
+
+	for i, layout := range isoLayouts {
+		t, err := time.Parse(layout, value)
+		if nil != err {
+			continue
+		}
+		dt := DateTime{
+			Year:       t.Year(),
+			Month:      int(t.Month()),
+			Day:        t.Day(),
+			Hour:       t.Hour(),
+			Minute:     t.Minute(),
+			Second:     t.Second(),
+			Nanosecond: t.Nanosecond(),
+			HasOffset:  0 == i,
+		}
+		_, dt.Offset = t.Zone()
+		return dt, nil
+	}
+	return DateTime{}, fmt.Errorf("%s is not an ISO-8601 date", value)
+}
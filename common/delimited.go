@@ -0,0 +1,234 @@
+/*
+ * MIT No Attribution
+ *
+ * Copyright 2021 Rickard Lundin (rickard@ignalina.dk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Delimited is the format of separated input, ie CSV or TSV, used instead of len positions
+type Delimited struct {
+	Separator  string // Between fields, ie , ; or tab
+	Quote      byte   // Quotes a field that may hold separators and record terminators, 0 for none
+	Escape     byte   // Makes the next character plain, 0 when a quote in a quoted field is doubled
+	Header     bool   // The first record names the fields
+	FieldIndex []int  // Field of each fixed field, -1 when the record does not have it
+}
+
+// NewDelimited checks the separator, quote and escape. tab or \t is a tab separator.
+func NewDelimited(separator string, quote string, escape string, header bool) (*Delimited, error) {
+	d := &Delimited{Separator: separator, Header: header}
+	if "tab" == separator || `\t` == separator {
+		d.Separator = "\t"
+	}
+	if len(quote) > 1 || len(escape) > 1 {
+		return nil, fmt.Errorf("quote %s and escape %s should be one character", quote, escape)
+	}
+	if 1 == len(quote) {
+		d.Quote = quote[0]
+	}
+	if 1 == len(escape) && escape[0] != d.Quote {
+		d.Escape = escape[0]
+	}
+	if strings.ContainsAny(d.Separator, "\r\n") || (0 != d.Quote && strings.IndexByte(d.Separator, d.Quote) >= 0) {
+		return nil, fmt.Errorf("separator %q can not hold the quote or a newline", d.Separator)
+	}
+	return d, nil
+}
+
+// MapFields maps the fixed fields to fields by position, filler takes its place. Arrays take one field per element.
+// Numbers without a decimal point are read as written unless the column has impliedScale.
+func (d *Delimited) MapFields(row *FixedRow) {
+	d.FieldIndex = make([]int, len(row.FixedField))
+	for i := range row.FixedField {
+		d.FieldIndex[i] = i
+		row.FixedField[i].WholeNumbers = !row.FixedField[i].ImpliedScale
+	}
+}
+
+// MapHeader maps the fixed fields to the fields of the header record by name, a column that is not in the header is an error
+func (d *Delimited) MapHeader(row *FixedRow, header []string) error {
+	d.FieldIndex = make([]int, len(row.FixedField))
+	for i, ff := range row.FixedField {
+		d.FieldIndex[i] = -1
+		if ff.Skip {
+			continue
+		}
+		if !ff.TopLevel() {
+			return fmt.Errorf("column %s in an array or record can not be mapped by header", ff.Name)
+		}
+		for fi, name := range header {
+			if strings.EqualFold(strings.TrimSpace(name), ff.Name) {
+				d.FieldIndex[i] = fi
+			}
+		}
+		if d.FieldIndex[i] < 0 {
+			return fmt.Errorf("column %s is not in the header", ff.Name)
+		}
+	}
+	return nil
+}
+
+// Split cuts a record into fields and removes the quotes. fields is reused, only fields with escaped quotes allocate.
+// Text between a closing quote and the separator is dropped.
+func (d *Delimited) Split(record string, fields []string) []string {
+	fields = fields[:0]
+	for i := 0; ; {
+		if 0 != d.Quote && i < len(record) && d.Quote == record[i] {
+			start := i + 1
+			j := start
+			plain := true
+			for j < len(record) {
+				c := record[j]
+				if 0 != d.Escape && d.Escape == c && j+1 < len(record) {
+					plain = false
+					j += 2
+					continue
+				}
+				if d.Quote == c {
+					if 0 == d.Escape && j+1 < len(record) && d.Quote == record[j+1] {
+						plain = false
+						j += 2
+						continue
+					}
+					break
+				}
+				j++
+			}
+			value := record[start:j]
+			if !plain {
+				value = d.unescape(value)
+			}
+			fields = append(fields, value)
+			if j >= len(record) {
+				return fields
+			}
+			k := strings.Index(record[j:], d.Separator)
+			if k < 0 {
+				return fields
+			}
+			i = j + k + len(d.Separator)
+			continue
+		}
+
+		k, plain := d.fieldEnd(record[i:])
+		end := i + k
+		if k < 0 {
+			end = len(record)
+		}
+		value := record[i:end]
+		if !plain {
+			value = d.unescape(value)
+		}
+		fields = append(fields, value)
+		if k < 0 {
+			return fields
+		}
+		i = end + len(d.Separator)
+	}
+}
+
+// fieldEnd returns the index of the separator ending an unquoted field, -1 for the last field, and false when the field has escapes
+func (d *Delimited) fieldEnd(field string) (int, bool) {
+	if 0 == d.Escape {
+		return strings.Index(field, d.Separator), true
+	}
+	plain := true
+	for j := 0; j < len(field); j++ {
+		if d.Escape == field[j] {
+			plain = false
+			j++
+			continue
+		}
+		if strings.HasPrefix(field[j:], d.Separator) {
+			return j, plain
+		}
+	}
+	return -1, plain
+}
+
+// unescape drops the escape characters, or the first of doubled quotes
+func (d *Delimited) unescape(value string) string {
+	var sb strings.Builder
+	sb.Grow(len(value))
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if (0 != d.Escape && d.Escape == c) || (0 == d.Escape && d.Quote == c) {
+			i++
+			if i >= len(value) {
+				break
+			}
+			c = value[i]
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
+
+// RecordEnd returns the length of the first record in buf including the terminator nl, -1 when buf holds no
+// terminator outside quotes. quote and escape are in the encoding of buf.
+func RecordEnd(buf []byte, nl []byte, quote byte, escape byte) int {
+	inQuotes := false
+	last := nl[len(nl)-1]
+	for i := 0; i < len(buf); i++ {
+		c := buf[i]
+		switch {
+		case 0 != escape && escape == c:
+			i++
+		case 0 != quote && quote == c:
+			inQuotes = !inQuotes
+		case !inQuotes && last == c && i+1 >= len(nl) && bytes.Equal(buf[i+1-len(nl):i+1], nl):
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// FindLastDelimitedRecord returns the end of the last record terminated within buf[from:to], from when there is none.
+// from must be the start of a record. Without escape the quotes before to are counted, which tells if to is quoted,
+// and the terminator is searched backwards. With escape every byte from from is looked at.
+func FindLastDelimitedRecord(buf []byte, from int, to int, nl []byte, quote byte, escape byte) int {
+	if 0 != escape {
+		end := from
+		for {
+			n := RecordEnd(buf[end:to], nl, quote, escape)
+			if n < 0 {
+				return end
+			}
+			end += n
+		}
+	}
+
+	inQuotes := 0 != quote && 1 == bytes.Count(buf[from:to], []byte{quote})%2
+	last := nl[len(nl)-1]
+	for i := to - 1; i >= from; i-- {
+		c := buf[i]
+		if 0 != quote && quote == c {
+			inQuotes = !inQuotes
+			continue
+		}
+		if !inQuotes && last == c && i+1-len(nl) >= from && bytes.Equal(buf[i+1-len(nl):i+1], nl) {
+			return i + 1
+		}
+	}
+	return from
+}
@@ -0,0 +1,78 @@
+/*
+ * MIT No Attribution
+ *
+ * Copyright 2021 Rickard Lundin (rickard@ignalina.dk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package common
+
+import (
+	"fmt"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"strings"
+)
+
+// GetEncoding maps an input encoding name to a single byte code page. utf8 (or empty) returns nil since no decoding is needed.
+func GetEncoding(name string) (encoding.Encoding, error) {
+
+	mapping := map[string]encoding.Encoding{
+		"utf8":        nil,
+		"utf-8":       nil,
+		"iso8859-1":   charmap.ISO8859_1,
+		"iso-8859-1":  charmap.ISO8859_1,
+		"latin1":      charmap.ISO8859_1,
+		"iso8859-15":  charmap.ISO8859_15,
+		"cp1252":      charmap.Windows1252,
+		"windows1252": charmap.Windows1252,
+		"cp037":       charmap.CodePage037,
+		"ebcdic":      charmap.CodePage037,
+		"cp1047":      charmap.CodePage1047,
+		"cp1140":      charmap.CodePage1140,
+	}
+
+	if "" == name {
+		return nil, nil
+	}
+
+	enc, ok := mapping[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown input encoding %s", name)
+	}
+	return enc, nil
+}
+
+// GetTerminator maps crlf, lf or none to the record terminator as utf8. none is fixed length records without terminator.
+func GetTerminator(name string) ([]byte, error) {
+
+	switch strings.ToLower(name) {
+	case "", "crlf":
+		return []byte("\r\n"), nil
+	case "lf":
+		return []byte("\n"), nil
+	case "none":
+		return []byte{}, nil
+	}
+	return nil, fmt.Errorf("unknown record terminator %s", name)
+}
+
+// EncodeTerminator returns the record terminator as it looks in the input encoding, ie \r\n is 0x0d 0x25 in EBCDIC.
+func EncodeTerminator(enc encoding.Encoding, terminator []byte) ([]byte, error) {
+	if nil == enc {
+		return terminator, nil
+	}
+	return enc.NewEncoder().Bytes(terminator)
+}
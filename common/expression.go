@@ -0,0 +1,1031 @@
+/*
+ * MIT No Attribution
+ *
+ * Copyright 2021 Rickard Lundin (rickard@ignalina.dk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package common
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Kinds of expression values
+const (
+	ExprNull = iota
+	ExprString
+	ExprInt
+	ExprFloat
+	ExprDecimal
+	ExprBool
+)
+
+var exprKindNames = []string{"null", "string", "int", "float", "decimal", "boolean"}
+
+// ExprValue is the value of an expression, Kind tells which field holds it
+type ExprValue struct {
+	Kind  int
+	Str   string
+	Int   int64
+	Float float64
+	Rat   *big.Rat
+	Bool  bool
+}
+
+// Expression is a compiled expression over the columns of a record, ie concat(First, ' ', Last) or Amount * 100
+type Expression struct {
+	Text string
+	Kind int // Kind of the values of the expression
+	root exprNode
+}
+
+type exprNode interface {
+	kind() int
+	eval(record reflect.Value) ExprValue
+}
+
+// ColumnRef is a column an expression reads
+type ColumnRef struct {
+	Path       []FieldStep // From the record struct
+	ColumnType string
+	Location   *time.Location // Time zone of timestamps, UTC when nil
+}
+
+// ColumnResolver finds a column by name, false when there is no such column
+type ColumnResolver func(name string) (ColumnRef, bool)
+
+// CompileExpression parses text into an expression. It has columns by name, 'text' (quote doubled inside), numbers,
+// true and false, + - * / and parentheses, and the functions concat, substr, trim, upper, lower and coalesce.
+// + of a string joins the values as text. Integer division gives a decimal, division by zero gives null.
+// Conditions compare with = != <> < <= > >=, test with in (...) and is [not] null, and combine with and, or and not.
+// A date or timestamp column compared to 'text' reads the text as ISO-8601 in the time zone of the column.
+func CompileExpression(text string, resolve ColumnResolver) (*Expression, error) {
+	tokens, err := scanExpression(text)
+	if nil != err {
+		return nil, err
+	}
+	p := exprParser{tokens: tokens, resolve: resolve}
+	root, err := p.parseExpression()
+	if nil != err {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %s", p.tokens[p.pos].text)
+	}
+	return &Expression{Text: text, Kind: root.kind(), root: root}, nil
+}
+
+// ExpressionColumns are the names of the columns text reads, the words that are not keywords or functions.
+// nil when text does not scan, CompileExpression then reports the error.
+func ExpressionColumns(text string) []string {
+	tokens, _ := scanExpression(text)
+	var columns []string
+	for i, t := range tokens {
+		if 'i' != t.kind || (i+1 < len(tokens) && 'o' == tokens[i+1].kind && "(" == tokens[i+1].text) {
+			continue
+		}
+		switch strings.ToLower(t.text) {
+		case "and", "or", "not", "is", "null", "in", "true", "false":
+			continue
+		}
+		columns = append(columns, t.text)
+	}
+	return columns
+}
+
+// Eval evaluates the expression on the record struct, a null column makes the expression null
+func (e *Expression) Eval(record reflect.Value) ExprValue {
+	return e.root.eval(record)
+}
+
+// ExprKindOf is the kind of the values of a column type, -1 for types expressions can not read
+func ExprKindOf(columnType string) int {
+	switch columnType {
+	case "string", "enum", "uuid":
+		return ExprString
+	case "int", "long", "date", "time-millis", "time-micros", "timestamp-millis", "timestamp-micros", "local-timestamp-millis", "local-timestamp-micros":
+		return ExprInt
+	case "float", "double":
+		return ExprFloat
+	case "decimal":
+		return ExprDecimal
+	case "boolean":
+		return ExprBool
+	}
+	return -1
+}
+
+// String is the value as text, empty for null
+func (v ExprValue) String() string {
+	switch v.Kind {
+	case ExprString:
+		return v.Str
+	case ExprInt:
+		return strconv.FormatInt(v.Int, 10)
+	case ExprFloat:
+		return strconv.FormatFloat(v.Float, 'f', -1, 64)
+	case ExprDecimal:
+		return RatString(v.Rat)
+	case ExprBool:
+		return strconv.FormatBool(v.Bool)
+	}
+	return ""
+}
+
+// Int64 is the value as an integer, decimals are truncated
+func (v ExprValue) Int64() (int64, bool) {
+	switch v.Kind {
+	case ExprString:
+		i, err := strconv.ParseInt(strings.TrimSpace(v.Str), 10, 64)
+		return i, nil == err
+	case ExprInt:
+		return v.Int, true
+	case ExprFloat:
+		return int64(v.Float), true
+	case ExprDecimal:
+		return new(big.Int).Quo(v.Rat.Num(), v.Rat.Denom()).Int64(), true
+	case ExprBool:
+		if v.Bool {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// Float64 is the value as a float
+func (v ExprValue) Float64() (float64, bool) {
+	switch v.Kind {
+	case ExprString:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v.Str), 64)
+		return f, nil == err
+	case ExprInt:
+		return float64(v.Int), true
+	case ExprFloat:
+		return v.Float, true
+	case ExprDecimal:
+		f, _ := v.Rat.Float64()
+		return f, true
+	}
+	return 0, false
+}
+
+// BigRat is the value as a decimal
+func (v ExprValue) BigRat() (*big.Rat, bool) {
+	switch v.Kind {
+	case ExprString:
+		return new(big.Rat).SetString(strings.TrimSpace(v.Str))
+	case ExprInt:
+		return new(big.Rat).SetInt64(v.Int), true
+	case ExprFloat:
+		r := new(big.Rat)
+		return r, nil != r.SetFloat64(v.Float)
+	case ExprDecimal:
+		return v.Rat, true
+	}
+	return nil, false
+}
+
+// Boolean is the value as a boolean, numbers are true when not 0
+func (v ExprValue) Boolean() (bool, bool) {
+	switch v.Kind {
+	case ExprString:
+		b, err := strconv.ParseBool(strings.TrimSpace(v.Str))
+		return b, nil == err
+	case ExprInt:
+		return 0 != v.Int, true
+	case ExprFloat:
+		return 0 != v.Float, true
+	case ExprDecimal:
+		return 0 != v.Rat.Sign(), true
+	case ExprBool:
+		return v.Bool, true
+	}
+	return false, false
+}
+
+// RatString writes a decimal without trailing zeros, ie 12.5
+func RatString(r *big.Rat) string {
+	if r.IsInt() {
+		return r.Num().String()
+	}
+	// Decimals read from the file have a power of 10 as denominator, others are cut at 18 digits
+	digits := 18
+	for d, pow := 1, big.NewInt(10); d < 18; d, pow = d+1, pow.Mul(pow, big.NewInt(10)) {
+		if 0 == new(big.Int).Rem(pow, r.Denom()).Sign() {
+			digits = d
+			break
+		}
+	}
+	return r.FloatString(digits)
+}
+
+type exprToken struct {
+	kind byte // n number, s string, i identifier, o operator
+	text string
+}
+
+func scanExpression(text string) ([]exprToken, error) {
+	var tokens []exprToken
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case ' ' == c || '\t' == c:
+			i++
+		case '\'' == c:
+			var sb strings.Builder
+			i++
+			for {
+				if i >= len(text) {
+					return nil, fmt.Errorf("unterminated string in %s", text)
+				}
+				if '\'' == text[i] {
+					if i+1 < len(text) && '\'' == text[i+1] {
+						sb.WriteByte('\'')
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				sb.WriteByte(text[i])
+				i++
+			}
+			tokens = append(tokens, exprToken{kind: 's', text: sb.String()})
+		case '0' <= c && c <= '9' || '.' == c:
+			start := i
+			for i < len(text) && ('0' <= text[i] && text[i] <= '9' || '.' == text[i]) {
+				i++
+			}
+			tokens = append(tokens, exprToken{kind: 'n', text: text[start:i]})
+		case '_' == c || unicode.IsLetter(rune(c)) || c >= utf8.RuneSelf:
+			start := i
+			for i < len(text) {
+				r, size := utf8.DecodeRuneInString(text[i:])
+				if '_' != r && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+					break
+				}
+				i += size
+			}
+			tokens = append(tokens, exprToken{kind: 'i', text: text[start:i]})
+		case strings.IndexByte("<>!", c) >= 0 && i+1 < len(text) && ('=' == text[i+1] || ("<" == text[i:i+1] && '>' == text[i+1])):
+			tokens = append(tokens, exprToken{kind: 'o', text: text[i : i+2]})
+			i += 2
+		case strings.IndexByte("+-*/(),=<>", c) >= 0:
+			tokens = append(tokens, exprToken{kind: 'o', text: text[i : i+1]})
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected %q in %s", c, text)
+		}
+	}
+	return tokens, nil
+}
+
+type exprParser struct {
+	tokens  []exprToken
+	pos     int
+	resolve ColumnResolver
+}
+
+// accept moves past the operator op when it is next
+func (p *exprParser) accept(op string) bool {
+	if p.pos < len(p.tokens) && 'o' == p.tokens[p.pos].kind && op == p.tokens[p.pos].text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// acceptWord moves past the keyword word when it is next
+func (p *exprParser) acceptWord(word string) bool {
+	if p.pos < len(p.tokens) && 'i' == p.tokens[p.pos].kind && strings.EqualFold(word, p.tokens[p.pos].text) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) parseExpression() (exprNode, error) {
+	return p.parseOr()
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	left, err := p.parseAnd()
+	for nil == err && p.acceptWord("or") {
+		var right exprNode
+		right, err = p.parseAnd()
+		if nil == err {
+			left, err = newLogicNode(false, left, right)
+		}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	left, err := p.parseNot()
+	for nil == err && p.acceptWord("and") {
+		var right exprNode
+		right, err = p.parseNot()
+		if nil == err {
+			left, err = newLogicNode(true, left, right)
+		}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseNot() (exprNode, error) {
+	if p.acceptWord("not") {
+		x, err := p.parseNot()
+		if nil != err {
+			return nil, err
+		}
+		if ExprBool != x.kind() {
+			return nil, fmt.Errorf("not of %s", exprKindNames[x.kind()])
+		}
+		return &notNode{x: x}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *exprParser) parseComparison() (exprNode, error) {
+	left, err := p.parseAdditive()
+	if nil != err {
+		return nil, err
+	}
+
+	if p.acceptWord("is") {
+		not := p.acceptWord("not")
+		if !p.acceptWord("null") {
+			return nil, fmt.Errorf("is needs null")
+		}
+		return &isNullNode{x: left, not: not}, nil
+	}
+	if p.acceptWord("in") {
+		if !p.accept("(") {
+			return nil, fmt.Errorf("in needs (")
+		}
+		n := &inNode{x: left}
+		for {
+			value, err := p.parseAdditive()
+			if nil != err {
+				return nil, err
+			}
+			compare, err := newCompareNode("=", left, value)
+			if nil != err {
+				return nil, err
+			}
+			n.values = append(n.values, compare.(*compareNode))
+			if p.accept(")") {
+				return n, nil
+			}
+			if !p.accept(",") {
+				return nil, fmt.Errorf("missing , or ) in in")
+			}
+		}
+	}
+	for _, op := range []string{"=", "!=", "<>", "<=", ">=", "<", ">"} {
+		if p.accept(op) {
+			right, err := p.parseAdditive()
+			if nil != err {
+				return nil, err
+			}
+			return newCompareNode(op, left, right)
+		}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAdditive() (exprNode, error) {
+	left, err := p.parseMultiplicative()
+	for nil == err {
+		var op byte
+		if p.accept("+") {
+			op = '+'
+		} else if p.accept("-") {
+			op = '-'
+		} else {
+			break
+		}
+		var right exprNode
+		right, err = p.parseMultiplicative()
+		if nil == err {
+			left, err = newArithNode(op, left, right)
+		}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseMultiplicative() (exprNode, error) {
+	left, err := p.parseUnary()
+	for nil == err {
+		var op byte
+		if p.accept("*") {
+			op = '*'
+		} else if p.accept("/") {
+			op = '/'
+		} else {
+			break
+		}
+		var right exprNode
+		right, err = p.parseUnary()
+		if nil == err {
+			left, err = newArithNode(op, left, right)
+		}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.accept("-") {
+		x, err := p.parseUnary()
+		if nil != err {
+			return nil, err
+		}
+		return newArithNode('-', &literalNode{value: ExprValue{Kind: ExprInt}}, x)
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+
+	switch t.kind {
+	case 's':
+		return &literalNode{value: ExprValue{Kind: ExprString, Str: t.text}}, nil
+	case 'n':
+		if !strings.Contains(t.text, ".") {
+			i, err := strconv.ParseInt(t.text, 10, 64)
+			if nil == err {
+				return &literalNode{value: ExprValue{Kind: ExprInt, Int: i}}, nil
+			}
+		}
+		r, ok := new(big.Rat).SetString(t.text)
+		if !ok {
+			return nil, fmt.Errorf("bad number %s", t.text)
+		}
+		return &literalNode{value: ExprValue{Kind: ExprDecimal, Rat: r}}, nil
+	case 'i':
+		if p.accept("(") {
+			return p.parseFunction(t.text)
+		}
+		switch strings.ToLower(t.text) {
+		case "true":
+			return &literalNode{value: ExprValue{Kind: ExprBool, Bool: true}}, nil
+		case "false":
+			return &literalNode{value: ExprValue{Kind: ExprBool}}, nil
+		}
+		column, ok := p.resolve(t.text)
+		if !ok {
+			return nil, fmt.Errorf("unknown column %s", t.text)
+		}
+		kind := ExprKindOf(column.ColumnType)
+		if kind < 0 {
+			return nil, fmt.Errorf("column %s of type %s can not be used in expressions", t.text, column.ColumnType)
+		}
+		return &columnNode{column: column, valueKind: kind}, nil
+	case 'o':
+		if "(" == t.text {
+			x, err := p.parseExpression()
+			if nil != err {
+				return nil, err
+			}
+			if !p.accept(")") {
+				return nil, fmt.Errorf("missing )")
+			}
+			return x, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected %s", t.text)
+}
+
+// parseFunction reads the arguments after the ( of a function call
+func (p *exprParser) parseFunction(name string) (exprNode, error) {
+	var args []exprNode
+	if !p.accept(")") {
+		for {
+			arg, err := p.parseExpression()
+			if nil != err {
+				return nil, err
+			}
+			args = append(args, arg)
+			if p.accept(")") {
+				break
+			}
+			if !p.accept(",") {
+				return nil, fmt.Errorf("missing , or ) in %s", name)
+			}
+		}
+	}
+
+	switch strings.ToLower(name) {
+	case "concat":
+		if 0 == len(args) {
+			return nil, fmt.Errorf("concat needs arguments")
+		}
+		return &concatNode{args: args}, nil
+	case "substr":
+		if len(args) < 2 || len(args) > 3 || ExprInt != args[1].kind() || (3 == len(args) && ExprInt != args[2].kind()) {
+			return nil, fmt.Errorf("substr needs a value, an integer start and an optional integer length")
+		}
+		return &substrNode{args: args}, nil
+	case "trim", "upper", "lower":
+		if 1 != len(args) {
+			return nil, fmt.Errorf("%s needs one argument", name)
+		}
+		f := strings.TrimSpace
+		if strings.EqualFold("upper", name) {
+			f = strings.ToUpper
+		} else if strings.EqualFold("lower", name) {
+			f = strings.ToLower
+		}
+		return &stringFuncNode{arg: args[0], f: f}, nil
+	case "coalesce":
+		if 0 == len(args) {
+			return nil, fmt.Errorf("coalesce needs arguments")
+		}
+		for _, arg := range args[1:] {
+			if arg.kind() != args[0].kind() {
+				return nil, fmt.Errorf("coalesce of %s and %s", exprKindNames[args[0].kind()], exprKindNames[arg.kind()])
+			}
+		}
+		return &coalesceNode{args: args}, nil
+	}
+	return nil, fmt.Errorf("unknown function %s", name)
+}
+
+type literalNode struct {
+	value ExprValue
+}
+
+func (n *literalNode) kind() int {
+	return n.value.Kind
+}
+
+func (n *literalNode) eval(record reflect.Value) ExprValue {
+	return n.value
+}
+
+type columnNode struct {
+	column    ColumnRef
+	valueKind int
+}
+
+func (n *columnNode) kind() int {
+	return n.valueKind
+}
+
+// Nullable columns are pointers, decimal always is
+func (n *columnNode) eval(record reflect.Value) ExprValue {
+	v := record
+	for _, step := range n.column.Path {
+		v = v.Field(step.FieldNr)
+	}
+	if reflect.Ptr == v.Kind() {
+		if v.IsNil() {
+			return ExprValue{}
+		}
+		if ExprDecimal == n.valueKind {
+			return ExprValue{Kind: ExprDecimal, Rat: v.Interface().(*big.Rat)}
+		}
+		v = v.Elem()
+	}
+	switch n.valueKind {
+	case ExprString:
+		return ExprValue{Kind: ExprString, Str: v.String()}
+	case ExprInt:
+		return ExprValue{Kind: ExprInt, Int: v.Int()}
+	case ExprFloat:
+		return ExprValue{Kind: ExprFloat, Float: v.Float()}
+	case ExprBool:
+		return ExprValue{Kind: ExprBool, Bool: v.Bool()}
+	}
+	return ExprValue{}
+}
+
+type arithNode struct {
+	op          byte
+	left, right exprNode
+	valueKind   int
+}
+
+// newArithNode decides the kind of the result: text when + has a string, int for int operands except division,
+// float when either is float and decimal otherwise
+func newArithNode(op byte, left exprNode, right exprNode) (exprNode, error) {
+	lk, rk := left.kind(), right.kind()
+	n := &arithNode{op: op, left: left, right: right}
+	switch {
+	case '+' == op && (ExprString == lk || ExprString == rk):
+		n.valueKind = ExprString
+	case ExprString == lk || ExprString == rk || ExprBool == lk || ExprBool == rk:
+		return nil, fmt.Errorf("%c of %s and %s", op, exprKindNames[lk], exprKindNames[rk])
+	case ExprInt == lk && ExprInt == rk && '/' != op:
+		n.valueKind = ExprInt
+	case ExprFloat == lk || ExprFloat == rk:
+		n.valueKind = ExprFloat
+	default:
+		n.valueKind = ExprDecimal
+	}
+	return n, nil
+}
+
+func (n *arithNode) kind() int {
+	return n.valueKind
+}
+
+func (n *arithNode) eval(record reflect.Value) ExprValue {
+	l := n.left.eval(record)
+	r := n.right.eval(record)
+	if ExprNull == l.Kind || ExprNull == r.Kind {
+		return ExprValue{}
+	}
+
+	switch n.valueKind {
+	case ExprString:
+		return ExprValue{Kind: ExprString, Str: l.String() + r.String()}
+	case ExprInt:
+		switch n.op {
+		case '+':
+			return ExprValue{Kind: ExprInt, Int: l.Int + r.Int}
+		case '-':
+			return ExprValue{Kind: ExprInt, Int: l.Int - r.Int}
+		default:
+			return ExprValue{Kind: ExprInt, Int: l.Int * r.Int}
+		}
+	case ExprFloat:
+		lf, _ := l.Float64()
+		rf, _ := r.Float64()
+		switch n.op {
+		case '+':
+			return ExprValue{Kind: ExprFloat, Float: lf + rf}
+		case '-':
+			return ExprValue{Kind: ExprFloat, Float: lf - rf}
+		case '*':
+			return ExprValue{Kind: ExprFloat, Float: lf * rf}
+		default:
+			if 0 == rf {
+				return ExprValue{}
+			}
+			return ExprValue{Kind: ExprFloat, Float: lf / rf}
+		}
+	}
+
+	lr, _ := l.BigRat()
+	rr, _ := r.BigRat()
+	result := new(big.Rat)
+	switch n.op {
+	case '+':
+		result.Add(lr, rr)
+	case '-':
+		result.Sub(lr, rr)
+	case '*':
+		result.Mul(lr, rr)
+	default:
+		if 0 == rr.Sign() {
+			return ExprValue{}
+		}
+		result.Quo(lr, rr)
+	}
+	return ExprValue{Kind: ExprDecimal, Rat: result}
+}
+
+type concatNode struct {
+	args []exprNode
+}
+
+func (n *concatNode) kind() int {
+	return ExprString
+}
+
+// Null arguments are left out
+func (n *concatNode) eval(record reflect.Value) ExprValue {
+	var sb strings.Builder
+	for _, arg := range n.args {
+		sb.WriteString(arg.eval(record).String())
+	}
+	return ExprValue{Kind: ExprString, Str: sb.String()}
+}
+
+type substrNode struct {
+	args []exprNode
+}
+
+func (n *substrNode) kind() int {
+	return ExprString
+}
+
+// substr(value, start, length) counts characters from 1
+func (n *substrNode) eval(record reflect.Value) ExprValue {
+	value := n.args[0].eval(record)
+	start := n.args[1].eval(record)
+	if ExprNull == value.Kind || ExprNull == start.Kind {
+		return ExprValue{}
+	}
+	s := value.String()
+	length := int64(len(s))
+	if 3 == len(n.args) {
+		l := n.args[2].eval(record)
+		if ExprNull == l.Kind {
+			return ExprValue{}
+		}
+		length = l.Int
+	}
+
+	from, to := len(s), len(s)
+	ci := int64(1)
+	for bi := range s {
+		if ci == start.Int {
+			from = bi
+		}
+		if ci == start.Int+length {
+			to = bi
+			break
+		}
+		ci++
+	}
+	if start.Int < 1 || length < 0 || from > to {
+		return ExprValue{Kind: ExprString}
+	}
+	return ExprValue{Kind: ExprString, Str: s[from:to]}
+}
+
+type stringFuncNode struct {
+	arg exprNode
+	f   func(string) string
+}
+
+func (n *stringFuncNode) kind() int {
+	return ExprString
+}
+
+func (n *stringFuncNode) eval(record reflect.Value) ExprValue {
+	v := n.arg.eval(record)
+	if ExprNull == v.Kind {
+		return v
+	}
+	return ExprValue{Kind: ExprString, Str: n.f(v.String())}
+}
+
+type coalesceNode struct {
+	args []exprNode
+}
+
+func (n *coalesceNode) kind() int {
+	return n.args[0].kind()
+}
+
+// The first value that is not null
+func (n *coalesceNode) eval(record reflect.Value) ExprValue {
+	for _, arg := range n.args {
+		v := arg.eval(record)
+		if ExprNull != v.Kind {
+			return v
+		}
+	}
+	return ExprValue{}
+}
+
+type compareNode struct {
+	op          string
+	left, right exprNode
+	valueKind   int // Kind both sides are compared as
+}
+
+// newCompareNode compares text with text, booleans with booleans and numbers with numbers. A date or timestamp
+// column compared to text has the text converted once to the value of the column.
+func newCompareNode(op string, left exprNode, right exprNode) (exprNode, error) {
+	var err error
+	left, err = dateLiteral(left, right)
+	if nil == err {
+		right, err = dateLiteral(right, left)
+	}
+	if nil != err {
+		return nil, err
+	}
+
+	lk, rk := left.kind(), right.kind()
+	n := &compareNode{op: op, left: left, right: right}
+	switch {
+	case lk == rk:
+		n.valueKind = lk
+	case ExprString == lk || ExprString == rk || ExprBool == lk || ExprBool == rk:
+		return nil, fmt.Errorf("%s of %s and %s", op, exprKindNames[lk], exprKindNames[rk])
+	case ExprFloat == lk || ExprFloat == rk:
+		n.valueKind = ExprFloat
+	default:
+		n.valueKind = ExprDecimal
+	}
+	if ExprBool == n.valueKind && "=" != op && "!=" != op && "<>" != op {
+		return nil, fmt.Errorf("%s of booleans", op)
+	}
+	return n, nil
+}
+
+// dateLiteral converts a text literal compared to a date or timestamp column to the value of the column
+func dateLiteral(x exprNode, other exprNode) (exprNode, error) {
+	literal, ok := x.(*literalNode)
+	column, isColumn := other.(*columnNode)
+	if !ok || !isColumn || ExprString != literal.value.Kind || ExprInt != column.valueKind {
+		return x, nil
+	}
+
+	columnType := column.column.ColumnType
+	if "int" == columnType || "long" == columnType {
+		return x, nil
+	}
+	dt, err := parseISO8601(literal.value.Str)
+	if nil != err {
+		return nil, err
+	}
+	loc := column.column.Location
+	if dt.HasOffset {
+		loc = time.FixedZone("", dt.Offset)
+	} else if nil == loc {
+		loc = time.UTC
+	}
+	t := time.Date(dt.Year, time.Month(dt.Month), dt.Day, dt.Hour, dt.Minute, dt.Second, dt.Nanosecond, loc)
+
+	value := ExprValue{Kind: ExprInt}
+	switch columnType {
+	case "date":
+		value.Int = dt.EpochDays()
+	case "time-millis":
+		value.Int = dt.NanosOfDay() / int64(time.Millisecond)
+	case "time-micros":
+		value.Int = dt.NanosOfDay() // Held as a time.Duration
+	case "timestamp-millis":
+		value.Int = t.UnixNano() / int64(time.Millisecond)
+	case "timestamp-micros":
+		value.Int = t.UnixNano() / int64(time.Microsecond)
+	case "local-timestamp-millis":
+		value.Int = dt.Local().UnixNano() / int64(time.Millisecond)
+	case "local-timestamp-micros":
+		value.Int = dt.Local().UnixNano() / int64(time.Microsecond)
+	}
+	return &literalNode{value: value}, nil
+}
+
+func (n *compareNode) kind() int {
+	return ExprBool
+}
+
+// Null on either side is null
+func (n *compareNode) eval(record reflect.Value) ExprValue {
+	l := n.left.eval(record)
+	r := n.right.eval(record)
+	if ExprNull == l.Kind || ExprNull == r.Kind {
+		return ExprValue{}
+	}
+
+	var c int
+	switch n.valueKind {
+	case ExprString:
+		c = strings.Compare(l.Str, r.Str)
+	case ExprBool:
+		if l.Bool != r.Bool {
+			c = 1
+		}
+	case ExprInt:
+		if l.Int < r.Int {
+			c = -1
+		} else if l.Int > r.Int {
+			c = 1
+		}
+	case ExprFloat:
+		lf, _ := l.Float64()
+		rf, _ := r.Float64()
+		if lf < rf {
+			c = -1
+		} else if lf > rf {
+			c = 1
+		}
+	default:
+		lr, _ := l.BigRat()
+		rr, _ := r.BigRat()
+		c = lr.Cmp(rr)
+	}
+
+	result := false
+	switch n.op {
+	case "=":
+		result = 0 == c
+	case "!=", "<>":
+		result = 0 != c
+	case "<":
+		result = c < 0
+	case "<=":
+		result = c <= 0
+	case ">":
+		result = c > 0
+	case ">=":
+		result = c >= 0
+	}
+	return ExprValue{Kind: ExprBool, Bool: result}
+}
+
+type logicNode struct {
+	and         bool
+	left, right exprNode
+}
+
+func newLogicNode(and bool, left exprNode, right exprNode) (exprNode, error) {
+	if ExprBool != left.kind() || ExprBool != right.kind() {
+		return nil, fmt.Errorf("and, or of %s and %s", exprKindNames[left.kind()], exprKindNames[right.kind()])
+	}
+	return &logicNode{and: and, left: left, right: right}, nil
+}
+
+func (n *logicNode) kind() int {
+	return ExprBool
+}
+
+// Null is unknown, false and null is false and true or null is true
+func (n *logicNode) eval(record reflect.Value) ExprValue {
+	l := n.left.eval(record)
+	if ExprBool == l.Kind && l.Bool != n.and {
+		return l
+	}
+	r := n.right.eval(record)
+	if ExprBool == r.Kind && r.Bool != n.and {
+		return r
+	}
+	if ExprNull == l.Kind || ExprNull == r.Kind {
+		return ExprValue{}
+	}
+	return r
+}
+
+type notNode struct {
+	x exprNode
+}
+
+func (n *notNode) kind() int {
+	return ExprBool
+}
+
+func (n *notNode) eval(record reflect.Value) ExprValue {
+	v := n.x.eval(record)
+	if ExprNull == v.Kind {
+		return v
+	}
+	return ExprValue{Kind: ExprBool, Bool: !v.Bool}
+}
+
+type isNullNode struct {
+	x   exprNode
+	not bool
+}
+
+func (n *isNullNode) kind() int {
+	return ExprBool
+}
+
+func (n *isNullNode) eval(record reflect.Value) ExprValue {
+	null := ExprNull == n.x.eval(record).Kind
+	return ExprValue{Kind: ExprBool, Bool: null != n.not}
+}
+
+type inNode struct {
+	x      exprNode
+	values []*compareNode // x = value for each value
+}
+
+func (n *inNode) kind() int {
+	return ExprBool
+}
+
+func (n *inNode) eval(record reflect.Value) ExprValue {
+	result := ExprValue{Kind: ExprBool}
+	for _, compare := range n.values {
+		v := compare.eval(record)
+		if ExprNull == v.Kind {
+			result = v
+		} else if v.Bool {
+			return v
+		}
+	}
+	return result
+}
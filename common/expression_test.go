@@ -0,0 +1,227 @@
+/*
+ * MIT No Attribution
+ *
+ * Copyright 2021 Rickard Lundin (rickard@ignalina.dk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package common
+
+import (
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// exprRecord is a record struct the way the builders fill it, nullable columns are pointers
+type exprRecord struct {
+	A   int64
+	F   float64
+	D   *big.Rat
+	S   string
+	N   *string
+	NI  *int64
+	Day int32
+	TS  int64
+}
+
+var exprColumnTypes = map[string]string{
+	"A":   "long",
+	"F":   "double",
+	"D":   "decimal",
+	"S":   "string",
+	"N":   "string",
+	"NI":  "long",
+	"Day": "date",
+	"TS":  "timestamp-millis",
+}
+
+var stockholm, _ = time.LoadLocation("Europe/Stockholm")
+
+func exprResolver(name string) (ColumnRef, bool) {
+	columnType, ok := exprColumnTypes[name]
+	if !ok {
+		return ColumnRef{}, false
+	}
+	field, _ := reflect.TypeOf(exprRecord{}).FieldByName(name)
+	return ColumnRef{Path: []FieldStep{{FieldNr: field.Index[0], Index: -1}}, ColumnType: columnType, Location: stockholm}, true
+}
+
+func exprTestRecord() reflect.Value {
+	return reflect.ValueOf(exprRecord{
+		A:   7,
+		F:   2.5,
+		D:   big.NewRat(25, 2),
+		S:   "  Hello ",
+		Day: int32(time.Date(2021, 3, 15, 0, 0, 0, 0, time.UTC).Unix() / 86400),
+		TS:  time.Date(2021, 3, 15, 10, 0, 0, 0, stockholm).UnixNano() / int64(time.Millisecond),
+	})
+}
+
+// exprCase is an expression and the kind and text of its value on exprTestRecord
+type exprCase struct {
+	text string
+	kind int
+	want string
+}
+
+func testExpressions(t *testing.T, cases []exprCase) {
+	record := exprTestRecord()
+	for _, c := range cases {
+		e, err := CompileExpression(c.text, exprResolver)
+		if nil != err {
+			t.Errorf("%s: %v", c.text, err)
+			continue
+		}
+		v := e.Eval(record)
+		if c.kind != v.Kind || c.want != v.String() {
+			t.Errorf("%s = %s %q want %s %q", c.text, exprKindNames[v.Kind], v.String(), exprKindNames[c.kind], c.want)
+		}
+	}
+}
+
+func testExpressionErrors(t *testing.T, cases map[string]string) {
+	for text, want := range cases {
+		_, err := CompileExpression(text, exprResolver)
+		if nil == err || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: error %v want %s", text, err, want)
+		}
+	}
+}
+
+func TestExpression(t *testing.T) {
+	testExpressions(t, []exprCase{
+		// Precedence and associativity
+		{"1 + 2 * 3", ExprInt, "7"},
+		{"(1 + 2) * 3", ExprInt, "9"},
+		{"A - 2 - 3", ExprInt, "2"},
+		{"-A * 2", ExprInt, "-14"},
+		{"- -A", ExprInt, "7"},
+
+		// Kinds of results
+		{"7 / 2", ExprDecimal, "3.5"},
+		{"1 / 3", ExprDecimal, "0.333333333333333333"},
+		{"A + 0.25", ExprDecimal, "7.25"},
+		{"D * 2", ExprDecimal, "25"},
+		{"D + F", ExprFloat, "15"},
+		{"S + A", ExprString, "  Hello 7"},
+		{"'it''s'", ExprString, "it's"},
+
+		// Division by zero
+		{"A / 0", ExprNull, ""},
+		{"F / 0", ExprNull, ""},
+		{"D / (A - 7)", ExprNull, ""},
+
+		// Null propagation
+		{"NI + 1", ExprNull, ""},
+		{"N + 'x'", ExprNull, ""},
+		{"coalesce(NI, A)", ExprInt, "7"},
+		{"coalesce(N, 'none')", ExprString, "none"},
+		{"coalesce(NI, NI)", ExprNull, ""},
+		{"concat('a', N, S)", ExprString, "a  Hello "},
+
+		// String functions
+		{"trim(S)", ExprString, "Hello"},
+		{"upper(trim(S))", ExprString, "HELLO"},
+		{"lower(S)", ExprString, "  hello "},
+		{"upper(N)", ExprNull, ""},
+		{"concat(A, '-', F)", ExprString, "7-2.5"},
+		{"substr('abcdef', 2, 3)", ExprString, "bcd"},
+		{"substr('abcdef', 4)", ExprString, "def"},
+		{"substr('abcdef', 5, 10)", ExprString, "ef"},
+		{"substr('åäö', 2, 1)", ExprString, "ä"},
+		{"substr('abc', 0)", ExprString, ""},
+		{"substr('abc', 9)", ExprString, ""},
+		{"substr(N, 1)", ExprNull, ""},
+	})
+}
+
+func TestExpressionErrors(t *testing.T) {
+	testExpressionErrors(t, map[string]string{
+		"'open":          "unterminated string",
+		"(1 + 2":         "missing )",
+		"1 +":            "unexpected end of expression",
+		"1 2":            "unexpected 2",
+		"A # 1":          "unexpected '#'",
+		"Missing + 1":    "unknown column Missing",
+		"nope(1)":        "unknown function nope",
+		"S - 1":          "- of string and int",
+		"true * 2":       "* of boolean and int",
+		"coalesce(S, A)": "coalesce of string and int",
+		"substr(S)":      "substr needs",
+		"substr(S, 'a')": "substr needs",
+		"trim(S, S)":     "trim needs one argument",
+		"concat()":       "concat needs arguments",
+		"upper(S":        "missing , or ) in upper",
+	})
+}
+
+func TestCondition(t *testing.T) {
+	testExpressions(t, []exprCase{
+		// And binds before or, not before and
+		{"A > 5 and S = 'x' or true", ExprBool, "true"},
+		{"true or false and false", ExprBool, "true"},
+		{"(true or false) and false", ExprBool, "false"},
+		{"not A = 7", ExprBool, "false"},
+		{"not false and false", ExprBool, "false"},
+		{"A + 1 = 2 * 4", ExprBool, "true"},
+
+		// Mixed numbers
+		{"D = 12.5", ExprBool, "true"},
+		{"F < A", ExprBool, "true"},
+		{"D >= F * 5", ExprBool, "true"},
+		{"'b' > 'a'", ExprBool, "true"},
+		{"A <> 7", ExprBool, "false"},
+		{"A in (1, 7)", ExprBool, "true"},
+		{"S in ('a', 'b')", ExprBool, "false"},
+
+		// Null is unknown
+		{"NI = 1", ExprNull, ""},
+		{"not NI = 1", ExprNull, ""},
+		{"NI = 1 or true", ExprBool, "true"},
+		{"NI = 1 and false", ExprBool, "false"},
+		{"NI = 1 and true", ExprNull, ""},
+		{"NI in (1, 2)", ExprNull, ""},
+		{"A in (NI, 7)", ExprBool, "true"},
+		{"NI is null", ExprBool, "true"},
+		{"N is not null", ExprBool, "false"},
+		{"A is not null", ExprBool, "true"},
+
+		// Dates and timestamps compared to text, timestamps in the zone of the column unless the text has one
+		{"Day = '2021-03-15'", ExprBool, "true"},
+		{"Day < '2021-03-16'", ExprBool, "true"},
+		{"'2021-03-14' < Day", ExprBool, "true"},
+		{"Day in ('2021-01-01', '2021-03-15')", ExprBool, "true"},
+		{"TS = '2021-03-15T10:00:00'", ExprBool, "true"},
+		{"TS = '2021-03-15T09:00:00Z'", ExprBool, "true"},
+		{"TS > '2021-03-15T10:00:00+01:00'", ExprBool, "false"},
+	})
+}
+
+func TestConditionErrors(t *testing.T) {
+	testExpressionErrors(t, map[string]string{
+		"not 1":             "not of int",
+		"A is 1":            "is needs null",
+		"A in 1":            "in needs (",
+		"A in (1 2)":        "missing , or ) in in",
+		"S = 1":             "= of string and int",
+		"true < false":      "< of booleans",
+		"A and true":        "and, or of int and boolean",
+		"Day = 'yesterday'": "yesterday",
+		"A = 1 = 2":         "unexpected =",
+	})
+}
@@ -20,32 +20,193 @@
 package common
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/json"
+	"fmt"
 	"github.com/hamba/avro"
+	"golang.org/x/text/encoding"
 	"log"
+	"math/big"
 	"reflect"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
 )
 
 type FixedField struct {
-	Len        int
+	Name          string
+	FieldNr       int         // Field in the struct it is in, -1 for skipped columns and array elements
+	Path          []FieldStep // From the record struct to the value, nil for skipped columns
+	Skip          bool        // Filler or not projected, the column is consumed by position but not in the record
+	Len           int
+	ColumnType    string
+	Scale         int               // Implied decimals, ie 000012345 with scale 2 is 123.45
+	ImpliedScale  bool              // Delimited numbers without a decimal point get the implied scale too
+	WholeNumbers  bool              // Numbers without a decimal point are read as written, set for delimited columns without impliedScale
+	Precision     int               // Max number of digits for decimal
+	Usage         string            // Storage of the value, empty for text. comp-3 is packed decimal, comp a binary integer, comp-1/comp-2 a float, binary is bytes that are not decoded
+	Sign          string            // Zoned decimal sign: trailing, leading (overpunch) or trailing-separate, leading-separate
+	Overpunch     string            // Overpunch variant: ebcdic ({A-I positive, }J-R negative) or ascii (0-9 positive, p-y negative)
+	Format        string            // Date pattern of date and timestamp columns, ie yyyyMMdd
+	DateFormat    *DateFormat       // Format compiled once when the layout is read
+	TimeZone      string            // IANA time zone of timestamps, ie Europe/Stockholm, the layout timeZone when empty
+	Location      *time.Location    // TimeZone loaded, UTC when neither column nor layout has a time zone
+	Nullable      bool              // The type is a union with null
+	NullIf        []string          // Values that are null: spaces, zeros or a sentinel such as 0001-01-01. spaces when empty
+	Trim          string            // Padding removed before parsing: left, right, both or none. Default right for string, both for numbers and boolean
+	Pad           string            // Pad characters removed by Trim, space when empty
+	TrueValues    []string          // Boolean tokens that are true, ie 1, T, TRUE, S
+	FalseValues   []string          // Boolean tokens that are false
+	CaseSensitive bool              // Boolean tokens must match case
+	Symbols       []string          // Enum symbols
+	Codes         map[string]string // Enum code in the file to symbol, ie A to ACTIVE. A value that is a symbol needs no code
+	EnumDefault   string            // Enum symbol of unknown codes, the first symbol when the enum has no default
+	Size          int               // Bytes of a fixed
+	BytesFormat   string            // How bytes and fixed are written in the file: raw, hex or base64. raw when empty
+	FloatFormat   string            // comp-1 and comp-2 floats: hfp (IBM hexadecimal floating point) or ieee. hfp when empty
+}
+
+// Boolean tokens of columns without trueValues and falseValues, compared without case
+var DefaultTrueValues = []string{"Y", "J", "T", "1", "YES", "JA", "TRUE"}
+var DefaultFalseValues = []string{"N", "F", "0", "NO", "NEJ", "FALSE"}
+
+// PointScale is the scale of a number without a decimal point, 0 when it is read as written
+func (f FixedField) PointScale() int {
+	if f.WholeNumbers {
+		return 0
+	}
+	return f.Scale
+}
+
+// TopLevel is true for a column that is a field of the record itself, not in a nested record or array
+func (f FixedField) TopLevel() bool {
+	return 1 == len(f.Path) && f.Path[0].Index < 0
+}
+
+// DefaultTrim is the trim of a column without trim or justify, text is left justified and numbers are padded on either side.
+// Numbers padded with 0 are right justified, a trailing 0 is a digit. Binary, zoned and date fields are read by position and are not trimmed.
+func (f FixedField) DefaultTrim() string {
+	if f.IsBinary() || "" != f.Sign || nil != f.DateFormat {
+		return "none"
+	}
+	if f.IsNumber() && strings.ContainsRune(f.Pad, '0') {
+		return "left"
+	}
+	switch f.ColumnType {
+	case "string":
+		return "right"
+	case "int", "long", "float", "double", "decimal", "boolean", "enum", "uuid":
+		return "both"
+	}
+	return "none"
+}
+
+// IsNumber is true for int, long, float, double and decimal columns
+func (f FixedField) IsNumber() bool {
+	switch f.ColumnType {
+	case "int", "long", "float", "double", "decimal":
+		return true
+	}
+	return false
+}
+
+// IsBinary is true for fields that must be parsed as raw bytes, they can not be decoded as text.
+func (f FixedField) IsBinary() bool {
+	switch f.Usage {
+	case "comp-3", "comp", "comp-1", "comp-2", "binary":
+		return true
+	}
+	return false
+}
+
+// FieldStep is one step from the record struct towards a value, the field and the array element in it when Index is not -1
+type FieldStep struct {
+	FieldNr int
+	Index   int
+}
+
+// FixedArray is an OCCURS array, the fixed fields of its elements follow each other from FirstLeaf
+type FixedArray struct {
+	Path        []FieldStep // To the slice, nil when the array is skipped
+	Occurs      int         // Elements laid out in the record
+	DependingOn string      // Column with the number of elements present, OCCURS DEPENDING ON
+	CountLeaf   int         // Fixed field of DependingOn
+	FirstLeaf   int
+	Leaves      int  // Fixed fields of all elements
+	Nested      bool // The array is in an element of another array
+}
+
+// DerivedField is a column computed from an expression over the other columns, it takes no room in the record
+type DerivedField struct {
+	Name       string
+	FieldNr    int         // Field in the struct it is in, -1 when skipped
+	Path       []FieldStep // From the record struct to the value, nil when skipped
+	Skip       bool
 	ColumnType string
+	Nullable   bool
+	Expression string
+	Compiled   *Expression // Expression compiled once the columns are known
 }
 
 type FixedRow struct {
-	FixedField   []FixedField // For parsing
-	RecordStruct reflect.Type // For Avro serializing
+	FixedField   []FixedField   // For parsing, one per value in the record. Records and arrays are flattened
+	Arrays       []FixedArray   // OCCURS arrays
+	Derived      []DerivedField // Computed after the fixed fields are parsed, in layout order
+	RecordStruct reflect.Type   // For Avro serializing
+	Binary       bool           // Any binary field, records are then split on byte length instead of newline
+	ByteWidths   bool           // len is in bytes rather than characters (runes), from the schema attribute "widthUnit"
+	TimeZone     string         // Default time zone of timestamp columns, from the schema attribute "timeZone"
 }
 
-func (f FixedRow) CalRowLength() int {
+// Skips is true when filler or projection leaves columns out of the record
+func (f FixedRow) Skips() bool {
+	for _, ff := range f.FixedField {
+		if ff.Skip {
+			return true
+		}
+	}
+	for _, d := range f.Derived {
+		if d.Skip {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckLengths fails on columns without len, every column of a fixed width record needs one
+func (f FixedRow) CheckLengths() error {
+	for _, ff := range f.FixedField {
+		if ff.Len <= 0 {
+			return fmt.Errorf("column %s needs len", ff.Name)
+		}
+	}
+	return nil
+}
+
+// DependingOn is true when an array has OCCURS DEPENDING ON, the records then vary in length
+func (f FixedRow) DependingOn() bool {
+	for _, a := range f.Arrays {
+		if "" != a.DependingOn {
+			return true
+		}
+	}
+	return false
+}
+
+// DataLength is the record length without the record separator, with all OCCURS elements
+func (f FixedRow) DataLength() int {
 	sum := 0
 
 	for _, num := range f.FixedField {
 		sum += num.Len
 	}
-	return sum + 2
+	return sum
+}
+
+func (f FixedRow) CalRowLength(newline []byte) int {
+	return f.DataLength() + len(newline)
 }
 
 type avroBinaryBytes []byte
@@ -58,6 +219,15 @@ type FixedSizeTableChunk struct {
 	AvrobinaroValueBytes []avroBinaryBytes
 
 	LinesParsed       int
+	LinesFiltered     int            // Parsed lines not output because of the row filter
+	LinesRejected     int            // Parsed lines not output because a value does not parse
+	Rejected          error          // First value that does not parse
+	UnknownTypes      map[string]int // Records skipped by record type code not in the layout
+	ShortRecords      int            // Records skipped for being too short for the discriminator
+	First             bool           // First chunk of the file, holds the header lines
+	Last              bool           // Last chunk of the file, holds the trailer lines
+	Trailer           string         // First trailer record found in the chunk
+	HashTotal         big.Rat        // Sum of the hash total column
 	DurationReadChunk time.Duration
 	DurationToAvro    time.Duration
 	DurationToExport  time.Duration
@@ -81,17 +251,114 @@ type FixedSizeTable struct {
 	DurationToExport   time.Duration
 	DurationDoneExport time.Duration
 	BinarySchemaId     []byte
+	Encoding           string            // Name of the input encoding, empty means utf8
+	InputEncoding      encoding.Encoding // nil for utf8 input
+	Terminator         string            // crlf, lf or none
+	RecordTerminator   []byte            // Record separator as utf8, empty for fixed length records
+	Newline            []byte            // Record separator in the input encoding
+	Descriptor         string            // Variable length records (RECFM=VB), rdw when each record has a RDW, bdw when blocks also have a BDW
+	Discriminator      *Discriminator    // Multi record type files, nil for one layout
+	RecordTypes        []*FixedSizeTable // One table per record type, they share Bytes with this table
+	Code               string            // Discriminator value of a record type table
+	Name               string            // Record type name, appended to the output file name
+	Topic              string            // Kafka topic of a record type table, the topic argument when empty
+	HeaderLines        int               // Records to skip at the start of the file
+	HeaderPattern      string            // Leading records matching the regexp are skipped
+	TrailerLines       int               // Records at the end of the file that are trailer
+	TrailerPattern     string            // The first record matching the regexp starts the trailer
+	TrailerCount       string            // offset:len of the record count in the trailer
+	TrailerSum         string            // offset:len:column of the hash total in the trailer
+	Columns            string            // Comma separated projection, all columns when empty
+	Where              string            // Row filter, all rows are output when empty
+	Verbose            bool              // Log the output schema and the record types the row filter does not apply to
+	Delimiter          string            // Field separator of delimited input, fixed width when empty
+	Quote              string            // Quote character of delimited input, none when empty
+	Escape             string            // Escape character of delimited input, quotes are doubled when empty
+	HeaderRow          bool              // The first record of delimited input names the fields
+	Delimited          *Delimited        // Delimited input, nil for fixed width
+	Compression        string            // auto, none, gzip, zstd, bzip2 or xz. auto finds it from the magic bytes
+	CompressedSize     int               // Bytes read when the file was compressed, 0 otherwise
+	DurationDecompress time.Duration     // Time spent reading and decompressing
+	Stream             bool              // Read blocks from a stream instead of the whole file, stdin and named pipes are always streamed
+	BlockSize          string            // Size of the blocks read when streaming, ie 64MB
+	MemoryLimit        string            // Ceiling on the block buffers when streaming, ie 1GB
+	StreamedBytes      int               // Bytes read when streaming, Bytes only holds the input of a file read at once
+	Filter             *Expression       // Where compiled for the row of this table, nil when not filtered
+	LinesFiltered      int
+	LinesRejected      int
+	UnknownTypes       map[string]int
+	ShortRecords       int
+	HeaderRegexp       *regexp.Regexp
+	TrailerRegexp      *regexp.Regexp
+	TrailerPrefix      string // Literal start of the records the trailer pattern matches, empty when the pattern has none
+	TrailerRawPrefix   string // TrailerPrefix in the input encoding, for records that are not decoded
+	TrailerCountField  *TrailerField
+	TrailerSumField    *TrailerField
+}
+
+// DataLength is the record length without terminator, the longest one for multi record type files
+func (fst *FixedSizeTable) DataLength() int {
+	if nil == fst.Discriminator {
+		return fst.Row.DataLength()
+	}
+	max := 0
+	for _, rt := range fst.RecordTypes {
+		if rt.Row.DataLength() > max {
+			max = rt.Row.DataLength()
+		}
+	}
+	return max
+}
+
+// Binary is true when any record type has binary fields
+func (fst *FixedSizeTable) Binary() bool {
+	if nil == fst.Discriminator {
+		return fst.Row.Binary
+	}
+	for _, rt := range fst.RecordTypes {
+		if rt.Row.Binary {
+			return true
+		}
+	}
+	return false
+}
+
+// ByteWidths is true when any record type has its widths in bytes
+func (fst *FixedSizeTable) ByteWidths() bool {
+	if nil == fst.Discriminator {
+		return fst.Row.ByteWidths
+	}
+	for _, rt := range fst.RecordTypes {
+		if rt.Row.ByteWidths {
+			return true
+		}
+	}
+	return false
+}
+
+// ColumnList is the projection as a list, nil for all columns
+func (fst *FixedSizeTable) ColumnList() []string {
+	var columns []string
+	for _, c := range strings.Split(fst.Columns, ",") {
+		if c = strings.TrimSpace(c); "" != c {
+			columns = append(columns, c)
+		}
+	}
+	return columns
+}
+
+// RawRecords is true when records are split on byte length rather than on the terminator,
+// binary fields may contain newline bytes, some files have no terminator at all and variable
+// records carry their length in a descriptor word.
+func (fst *FixedSizeTable) RawRecords() bool {
+	return fst.Binary() || 0 == len(fst.Newline) || "" != fst.Descriptor
 }
 
 func CreateRowFromSchema(schemaAsString string) (*FixedRow, error) {
 
 	var fixedRow FixedRow
-	var columnLen float64
-	var columnName, columnType string
-
 	var v interface{}
 	sf := []reflect.StructField{}
-	ff := []FixedField{}
 
 	// Unmarshal or Decode the JSON to the interface.
 	json.Unmarshal([]byte(schemaAsString), &v)
@@ -100,65 +367,581 @@ func CreateRowFromSchema(schemaAsString string) (*FixedRow, error) {
 	for k, v := range data {
 		switch v := v.(type) {
 		case []interface{}:
+			var err error
+			sf, err = fixedRow.parseFields(v, nil, false)
+			if nil != err {
+				return nil, err
+			}
 
-			nrOfCols := len(v)
+		case string:
+			if "widthUnit" == k {
+				if "bytes" != v && "runes" != v {
+					return nil, fmt.Errorf("widthUnit %s should be bytes or runes", v)
+				}
+				fixedRow.ByteWidths = "bytes" == v
+			} else if "timeZone" == k {
+				fixedRow.TimeZone = v
+			}
 
-			ff = make([]FixedField, nrOfCols)
-			sf = make([]reflect.StructField, nrOfCols)
+		default:
+			log.Println("ignored (unknown)", k, v)
+		}
+	}
+	ff := fixedRow.FixedField
 
-			for i, u := range v {
-				maps := u.(map[string]interface{})
-				columnName = maps["name"].(string)
-				maps2 := maps["type"].(map[string]interface{})
-				columnLen = maps2["len"].(float64)
+	// The layout time zone is known first after all attributes are read
+	for i := range ff {
+		if "" == ff[i].TimeZone {
+			ff[i].TimeZone = fixedRow.TimeZone
+		}
+		loc, err := time.LoadLocation(ff[i].TimeZone)
+		if nil != err {
+			return nil, fmt.Errorf("time zone %s: %v", ff[i].TimeZone, err)
+		}
+		ff[i].Location = loc
+	}
 
-				for ii, uu := range maps2 {
+	// The count of OCCURS DEPENDING ON is a column before the array
+	for i := range fixedRow.Arrays {
+		a := &fixedRow.Arrays[i]
+		if "" == a.DependingOn {
+			continue
+		}
+		a.CountLeaf = -1
+		for j := 0; j < a.FirstLeaf; j++ {
+			if strings.EqualFold(ff[j].Name, a.DependingOn) {
+				a.CountLeaf = j
+			}
+		}
+		if a.CountLeaf < 0 {
+			return nil, fmt.Errorf("depending on %s is not a column before the array", a.DependingOn)
+		}
+		if a.Nested {
+			return nil, fmt.Errorf("depending on %s in an array element is not supported", a.DependingOn)
+		}
+	}
 
-					switch uu.(type) {
-					case string:
-						if ii == "type" {
-							columnType = uu.(string)
-						} else if ii == "logicalType" {
-							columnType = uu.(string)
-						}
+	// Derived columns read the columns of the record and the derived columns before them
+	for i := range fixedRow.Derived {
+		d := &fixedRow.Derived[i]
+		if d.Skip {
+			continue
+		}
+		expression, err := CompileExpression(d.Expression, fixedRow.resolver(i))
+		if nil != err {
+			return nil, fmt.Errorf("column %s expression %s: %v", d.Name, d.Expression, err)
+		}
+		d.Compiled = expression
+	}
+	fixedRow.RecordStruct = reflect.StructOf(sf)
 
-					}
-				}
+	return &fixedRow, nil
+}
 
-				ff[i] = FixedField{
-					Len:        int(columnLen),
-					ColumnType: columnType, // logical column type , for column parser factory.
+// resolver finds columns by name for the expression of derived column derivedNr, array elements and skipped columns are not in reach
+func (fixedRow *FixedRow) resolver(derivedNr int) ColumnResolver {
+	return func(name string) (ColumnRef, bool) {
+	leaves:
+		for _, ff := range fixedRow.FixedField {
+			if ff.Skip || !strings.EqualFold(ff.Name, name) {
+				continue
+			}
+			for _, step := range ff.Path {
+				if step.Index >= 0 {
+					continue leaves
 				}
+			}
+			return ColumnRef{Path: ff.Path, ColumnType: ff.ColumnType, Location: ff.Location}, true
+		}
+		for _, d := range fixedRow.Derived[:derivedNr] {
+			if !d.Skip && strings.EqualFold(d.Name, name) {
+				return ColumnRef{Path: d.Path, ColumnType: d.ColumnType}, true
+			}
+		}
+		return ColumnRef{}, false
+	}
+}
 
-				sf[i] = reflect.StructField{
-					Name: strings.Title(columnName),
-					Type: getGoTypeFromAvroType(columnType),
-				}
+// parseFields appends the fixed fields of a record and returns its struct fields. path leads from the record
+// struct to the struct of these fields, skip is set for the fields of a skipped record or array.
+func (fixedRow *FixedRow) parseFields(fields []interface{}, path []FieldStep, skip bool) ([]reflect.StructField, error) {
+	sf := make([]reflect.StructField, 0, len(fields))
 
+	for _, u := range fields {
+		maps := u.(map[string]interface{})
+		columnName := maps["name"].(string)
+		maps2, columnNullable := columnTypeOf(maps["type"])
+		if nil == maps2 {
+			return nil, fmt.Errorf("column %s needs a type with len, or a union of null and a type with len", columnName)
+		}
+		fieldPath := append(append([]FieldStep{}, path...), FieldStep{FieldNr: len(sf), Index: -1})
+		fieldSkip := skip || true == maps2["filler"] || true == maps2["skip"]
+
+		switch maps2["type"] {
+		case "record":
+			if columnNullable {
+				return nil, fmt.Errorf("record %s can not be nullable", columnName)
+			}
+			recordFields, ok := maps2["fields"].([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("record %s has no fields", columnName)
+			}
+			recordStruct, err := fixedRow.parseFields(recordFields, fieldPath, fieldSkip)
+			if nil != err {
+				return nil, err
+			}
+			if !fieldSkip {
+				sf = append(sf, reflect.StructField{Name: strings.Title(columnName), Type: reflect.StructOf(recordStruct)})
+			}
+
+		case "array":
+			elemType, err := fixedRow.parseArray(columnName, maps2, fieldPath, fieldSkip)
+			if nil != err {
+				return nil, err
+			}
+			if columnNullable {
+				return nil, fmt.Errorf("array %s can not be nullable", columnName)
+			}
+			if !fieldSkip {
+				sf = append(sf, reflect.StructField{Name: strings.Title(columnName), Type: reflect.SliceOf(elemType)})
 			}
 
 		default:
-			log.Println("ignored (unknown)", k, v)
+			if _, ok := maps2["expression"]; ok {
+				for _, step := range path {
+					if step.Index >= 0 {
+						return nil, fmt.Errorf("derived column %s in an array is not supported", columnName)
+					}
+				}
+				derived, fieldType, err := parseDerived(columnName, maps2, columnNullable)
+				if nil != err {
+					return nil, err
+				}
+				derived.Skip = fieldSkip
+				if !derived.Skip {
+					derived.FieldNr = len(sf)
+					derived.Path = fieldPath
+					sf = append(sf, reflect.StructField{Name: strings.Title(columnName), Type: fieldType})
+				}
+				fixedRow.Derived = append(fixedRow.Derived, derived)
+				continue
+			}
+			field, fieldType, err := parseColumn(columnName, maps2, columnNullable)
+			if nil != err {
+				return nil, err
+			}
+			if field.IsBinary() {
+				fixedRow.Binary = true
+			}
+			field.Skip = field.Skip || fieldSkip
+			if !field.Skip {
+				field.FieldNr = len(sf)
+				field.Path = fieldPath
+				sf = append(sf, reflect.StructField{Name: strings.Title(columnName), Type: fieldType})
+			}
+			fixedRow.FixedField = append(fixedRow.FixedField, field)
 		}
 	}
-	fixedRow.FixedField = ff
-	fixedRow.RecordStruct = reflect.StructOf(sf)
+	return sf, nil
+}
 
-	return &fixedRow, nil
+// parseArray appends the fixed fields of all elements of an OCCURS array and returns the element type
+func (fixedRow *FixedRow) parseArray(columnName string, maps2 map[string]interface{}, path []FieldStep, skip bool) (reflect.Type, error) {
+	occurs, _ := maps2["occurs"].(float64)
+	if occurs < 1 {
+		return nil, fmt.Errorf("array %s needs occurs", columnName)
+	}
+	items, itemsNullable := columnTypeOf(maps2["items"])
+	if nil == items {
+		return nil, fmt.Errorf("array %s needs items with len, or a record", columnName)
+	}
+	dependingOn, _ := maps2["dependingOn"].(string)
+
+	// The array goes before the arrays of its elements, the slice is then made first
+	arrayNr := len(fixedRow.Arrays)
+	fixedRow.Arrays = append(fixedRow.Arrays, FixedArray{
+		Occurs:      int(occurs),
+		DependingOn: dependingOn,
+		FirstLeaf:   len(fixedRow.FixedField),
+	})
+	for _, step := range path {
+		if step.Index >= 0 {
+			fixedRow.Arrays[arrayNr].Nested = true
+		}
+	}
+	if !skip {
+		fixedRow.Arrays[arrayNr].Path = path
+	}
+
+	var elemType reflect.Type
+	for i := 0; i < int(occurs); i++ {
+		elemPath := append([]FieldStep{}, path...)
+		elemPath[len(elemPath)-1].Index = i
+
+		if "record" == items["type"] {
+			recordFields, ok := items["fields"].([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("array %s items record has no fields", columnName)
+			}
+			recordStruct, err := fixedRow.parseFields(recordFields, elemPath, skip)
+			if nil != err {
+				return nil, err
+			}
+			elemType = reflect.StructOf(recordStruct)
+			continue
+		}
+
+		field, fieldType, err := parseColumn(columnName, items, itemsNullable)
+		if nil != err {
+			return nil, err
+		}
+		if field.IsBinary() {
+			fixedRow.Binary = true
+		}
+		field.Skip = field.Skip || skip
+		if !field.Skip {
+			field.Path = elemPath
+		}
+		fixedRow.FixedField = append(fixedRow.FixedField, field)
+		elemType = fieldType
+	}
+	fixedRow.Arrays[arrayNr].Leaves = len(fixedRow.FixedField) - fixedRow.Arrays[arrayNr].FirstLeaf
+	return elemType, nil
 }
 
-func FindLastNL(bytes []byte) int {
-	p2 := len(bytes)
+// CompileFilter compiles a row filter, a condition over the columns and derived columns of the record
+func (fixedRow *FixedRow) CompileFilter(where string) (*Expression, error) {
+	filter, err := CompileExpression(where, fixedRow.resolver(len(fixedRow.Derived)))
+	if nil != err {
+		return nil, err
+	}
+	if ExprBool != filter.Kind {
+		return nil, fmt.Errorf("%s is not a condition", where)
+	}
+	return filter, nil
+}
+
+// parseDerived reads a column with an expression instead of len
+func parseDerived(columnName string, maps2 map[string]interface{}, columnNullable bool) (DerivedField, reflect.Type, error) {
+	expression, _ := maps2["expression"].(string)
+	columnType, _ := maps2["type"].(string)
+	if logicalType, ok := maps2["logicalType"].(string); ok {
+		columnType = logicalType
+	}
+	d := DerivedField{
+		Name:       columnName,
+		FieldNr:    -1,
+		ColumnType: columnType,
+		Nullable:   columnNullable,
+		Expression: expression,
+	}
+
+	fieldType := getGoTypeFromAvroType(columnType)
+	if nil == fieldType || ExprKindOf(columnType) < 0 || "enum" == columnType {
+		return d, nil, fmt.Errorf("derived column %s can not be of type %s", columnName, columnType)
+	}
+	if columnNullable && reflect.Ptr != fieldType.Kind() {
+		fieldType = reflect.PtrTo(fieldType)
+	}
+	return d, fieldType, nil
+}
+
+// parseColumn reads the attributes of a type object with len into a fixed field and its Go type
+func parseColumn(columnName string, maps2 map[string]interface{}, columnNullable bool) (FixedField, reflect.Type, error) {
+	var columnLen, columnScale, columnPrecision, columnSize float64
+	var columnNullIf, columnTrueValues, columnFalseValues, columnSymbols []string
+	var columnCodes map[string]string
+	var columnCaseSensitive, columnImpliedScale, columnSkip bool
+	var columnType, columnLogicalType, columnUsage, columnSign, columnOverpunch, columnFormat, columnTimeZone, columnTrim, columnJustify, columnPad, columnDefault, columnBytesFormat, columnFloatFormat string
+
+	// Delimited input has no len, fixed width layouts are checked by CheckLengths
+	columnLen, _ = maps2["len"].(float64)
+
+	for ii, uu := range maps2 {
+
+		switch uu.(type) {
+		case string:
+			if ii == "type" {
+				columnType = uu.(string)
+			} else if ii == "logicalType" {
+				columnLogicalType = uu.(string)
+			} else if ii == "usage" {
+				columnUsage = uu.(string)
+			} else if ii == "sign" {
+				columnSign = uu.(string)
+			} else if ii == "overpunch" {
+				columnOverpunch = uu.(string)
+			} else if ii == "format" {
+				columnFormat = uu.(string)
+			} else if ii == "timeZone" {
+				columnTimeZone = uu.(string)
+			} else if ii == "nullIf" {
+				columnNullIf = []string{uu.(string)}
+			} else if ii == "trim" {
+				columnTrim = uu.(string)
+			} else if ii == "justify" {
+				columnJustify = uu.(string)
+			} else if ii == "pad" {
+				columnPad = uu.(string)
+			} else if ii == "default" {
+				columnDefault = uu.(string)
+			} else if ii == "bytesFormat" {
+				columnBytesFormat = uu.(string)
+			} else if ii == "floatFormat" {
+				columnFloatFormat = uu.(string)
+			}
+		case []interface{}:
+			if ii == "nullIf" {
+				for _, n := range uu.([]interface{}) {
+					columnNullIf = append(columnNullIf, fmt.Sprint(n))
+				}
+			} else if ii == "trueValues" {
+				for _, n := range uu.([]interface{}) {
+					columnTrueValues = append(columnTrueValues, fmt.Sprint(n))
+				}
+			} else if ii == "falseValues" {
+				for _, n := range uu.([]interface{}) {
+					columnFalseValues = append(columnFalseValues, fmt.Sprint(n))
+				}
+			} else if ii == "symbols" {
+				for _, n := range uu.([]interface{}) {
+					columnSymbols = append(columnSymbols, fmt.Sprint(n))
+				}
+			}
+		case map[string]interface{}:
+			if ii == "codes" {
+				columnCodes = map[string]string{}
+				for code, symbol := range uu.(map[string]interface{}) {
+					columnCodes[code] = fmt.Sprint(symbol)
+				}
+			}
+		case bool:
+			if ii == "caseSensitive" {
+				columnCaseSensitive = uu.(bool)
+			} else if ii == "impliedScale" {
+				columnImpliedScale = uu.(bool)
+			} else if ii == "filler" || ii == "skip" {
+				columnSkip = columnSkip || uu.(bool)
+			}
+		case float64:
+			if ii == "scale" {
+				columnScale = uu.(float64)
+			} else if ii == "precision" {
+				columnPrecision = uu.(float64)
+			} else if ii == "size" {
+				columnSize = uu.(float64)
+			}
+
+		}
+	}
+	// The logical type decides the parser when present, ie decimal on bytes
+	if "" != columnLogicalType {
+		columnType = columnLogicalType
+	}
+
+	ff := FixedField{
+		Name:          columnName,
+		FieldNr:       -1,
+		Skip:          columnSkip,
+		Len:           int(columnLen),
+		ColumnType:    columnType, // logical column type , for column parser factory.
+		Scale:         int(columnScale),
+		Precision:     int(columnPrecision),
+		Usage:         columnUsage,
+		Sign:          columnSign,
+		Overpunch:     columnOverpunch,
+		Format:        columnFormat,
+		TimeZone:      columnTimeZone,
+		Nullable:      columnNullable,
+		NullIf:        columnNullIf,
+		Pad:           columnPad,
+		TrueValues:    columnTrueValues,
+		FalseValues:   columnFalseValues,
+		CaseSensitive: columnCaseSensitive,
+		ImpliedScale:  columnImpliedScale,
+		Symbols:       columnSymbols,
+		Codes:         columnCodes,
+		Size:          int(columnSize),
+		BytesFormat:   columnBytesFormat,
+		FloatFormat:   columnFloatFormat,
+	}
+	if "boolean" == columnType && nil == columnTrueValues && nil == columnFalseValues {
+		ff.TrueValues = DefaultTrueValues
+		ff.FalseValues = DefaultFalseValues
+	}
+	if "" == columnFormat {
+		columnFormat = DefaultDateFormat(columnType)
+	}
+	if "" != columnFormat {
+		df, err := CompileDateFormat(columnFormat)
+		if nil != err {
+			return ff, nil, err
+		}
+		ff.DateFormat = df
+	}
+
+	// Justification tells on which side the padding is
+	switch columnJustify {
+	case "left":
+		columnTrim = "right"
+	case "right":
+		columnTrim = "left"
+	case "":
+	default:
+		return ff, nil, fmt.Errorf("column %s justify %s should be left or right", columnName, columnJustify)
+	}
+	if ff.Scale < 0 || ff.Precision < 0 {
+		return ff, nil, fmt.Errorf("column %s scale %d and precision %d can not be negative", columnName, ff.Scale, ff.Precision)
+	}
+	if "" == ff.Pad {
+		ff.Pad = " "
+	}
+	switch columnTrim {
+	case "":
+		ff.Trim = ff.DefaultTrim()
+	case "left", "right", "both", "none":
+		ff.Trim = columnTrim
+	default:
+		return ff, nil, fmt.Errorf("column %s trim %s should be left, right, both or none", columnName, columnTrim)
+	}
+	// A 0 trimmed on the right of a number is a digit lost
+	if ff.IsNumber() && strings.ContainsRune(ff.Pad, '0') && ("right" == ff.Trim || "both" == ff.Trim) {
+		return ff, nil, fmt.Errorf("column %s padded with 0 can not be trimmed %s, use left", columnName, ff.Trim)
+	}
+
+	switch columnType {
+	case "enum":
+		err := ff.checkEnum(columnDefault)
+		if nil != err {
+			return ff, nil, err
+		}
+	case "fixed":
+		if ff.Size <= 0 {
+			return ff, nil, fmt.Errorf("column %s fixed needs size", columnName)
+		}
+		if ("" == ff.BytesFormat || "raw" == ff.BytesFormat) && ff.Len != ff.Size {
+			return ff, nil, fmt.Errorf("column %s fixed of size %d has len %d", columnName, ff.Size, ff.Len)
+		}
+	}
+	switch ff.BytesFormat {
+	case "", "raw", "hex", "base64":
+	default:
+		return ff, nil, fmt.Errorf("column %s bytesFormat %s should be raw, hex or base64", columnName, ff.BytesFormat)
+	}
+
+	// Binary numbers are read by their byte length, delimited input has no binary fields
+	switch columnUsage {
+	case "", "comp-3", "binary":
+	case "comp":
+		if ff.Len < 1 || ff.Len > 8 {
+			return ff, nil, fmt.Errorf("column %s comp needs len 1 to 8", columnName)
+		}
+	case "comp-1", "comp-2":
+		if 4 != ff.Len && 8 != ff.Len {
+			return ff, nil, fmt.Errorf("column %s %s needs len 4 or 8", columnName, columnUsage)
+		}
+	default:
+		return ff, nil, fmt.Errorf("column %s usage %s should be comp, comp-1, comp-2, comp-3 or binary", columnName, columnUsage)
+	}
+	switch ff.FloatFormat {
+	case "", "hfp", "ieee":
+	default:
+		return ff, nil, fmt.Errorf("column %s floatFormat %s should be hfp or ieee", columnName, ff.FloatFormat)
+	}
+
+	fieldType := getGoTypeFromAvroType(columnType)
+	if "fixed" == columnType {
+		fieldType = reflect.ArrayOf(ff.Size, reflect.TypeOf(byte(0)))
+	}
+	// Avro null is a nil pointer, decimal is already a pointer
+	if columnNullable && nil != fieldType && reflect.Ptr != fieldType.Kind() {
+		fieldType = reflect.PtrTo(fieldType)
+	}
+	return ff, fieldType, nil
+}
+
+// checkEnum validates the codes and the default against the symbols, the default is the first symbol when not given
+func (f *FixedField) checkEnum(enumDefault string) error {
+	if 0 == len(f.Symbols) {
+		return fmt.Errorf("column %s enum needs symbols", f.Name)
+	}
+	for code, symbol := range f.Codes {
+		if !f.IsSymbol(symbol) {
+			return fmt.Errorf("column %s code %s maps to %s which is not a symbol", f.Name, code, symbol)
+		}
+	}
+	if "" == enumDefault {
+		enumDefault = f.Symbols[0]
+	}
+	if !f.IsSymbol(enumDefault) {
+		return fmt.Errorf("column %s default %s is not a symbol", f.Name, enumDefault)
+	}
+	f.EnumDefault = enumDefault
+	return nil
+}
+
+// IsSymbol is true when value is one of the enum symbols
+func (f FixedField) IsSymbol(value string) bool {
+	for _, s := range f.Symbols {
+		if s == value {
+			return true
+		}
+	}
+	return false
+}
+
+// columnTypeOf returns the type object of a column and if the type is a union with null, ie ["null", {"type": "long", "len": 8}]
+func columnTypeOf(t interface{}) (map[string]interface{}, bool) {
+	switch t := t.(type) {
+	case map[string]interface{}:
+		return t, false
+	case []interface{}:
+		var m map[string]interface{}
+		nullable := false
+		for _, u := range t {
+			if "null" == u {
+				nullable = true
+			} else if um, ok := u.(map[string]interface{}); ok {
+				m = um
+			}
+		}
+		return m, nullable
+	}
+	return nil, false
+}
+
+func FindLastNL(buf []byte, nl []byte) int {
+	p2 := len(buf)
 	if 0 == p2 {
 		return -1
 	}
 
-	for p2 > 2 {
-		if bytes[p2-2] == 0x0d && bytes[p2-1] == 0x0a {
-			return p2
-		}
-		p2--
+	p := bytes.LastIndex(buf, nl)
+	if p < 0 {
+		return 0
 	}
 
-	return 0
+	return p + len(nl)
+}
+
+// DescriptorLength returns the length from a RDW or BDW, including the 4 descriptor bytes.
+// A BDW with the high bit set is an extended 31 bit block length.
+func DescriptorLength(descriptor []byte) int {
+	if descriptor[0]&0x80 != 0 {
+		return int(binary.BigEndian.Uint32(descriptor[:4]) & 0x7fffffff)
+	}
+	return int(binary.BigEndian.Uint16(descriptor[:2]))
+}
+
+// FindLastDescriptor walks the descriptor words from pos and returns the end of the last complete record (or block) before end.
+// Variable records can not be found from the middle of the file, so the walk must start where the previous chunk ended.
+func FindLastDescriptor(buf []byte, pos int, end int) int {
+	for pos+4 <= end {
+		length := DescriptorLength(buf[pos:])
+		if length < 4 || pos+length > end {
+			break
+		}
+		pos += length
+	}
+	return pos
 }
@@ -0,0 +1,82 @@
+/*
+ * MIT No Attribution
+ *
+ * Copyright 2021 Rickard Lundin (rickard@ignalina.dk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+)
+
+// Layout describes a file with several record types, ie header, detail and trailer.
+// The discriminator picks the record type and every record type has its own schema and output.
+type Layout struct {
+	Discriminator Discriminator  `json:"discriminator"`
+	Records       []LayoutRecord `json:"records"`
+}
+
+// Discriminator is the byte position of the record type code
+type Discriminator struct {
+	Offset int `json:"offset"`
+	Len    int `json:"len"`
+}
+
+type LayoutRecord struct {
+	Code     string `json:"code"`     // Value at the discriminator position
+	Name     string `json:"name"`     // Appended to the output file name
+	Schema   string `json:"schema"`   // Schema file, relative to the layout file
+	SchemaID int    `json:"schemaId"` // Schema registry id
+	Topic    string `json:"topic"`    // Kafka topic, the topic argument when empty
+}
+
+// ReadLayout returns nil when the file is a plain Avro schema rather than a layout.
+func ReadLayout(layoutAsString string, layoutPath string) (*Layout, error) {
+	var v map[string]interface{}
+
+	err := json.Unmarshal([]byte(layoutAsString), &v)
+	if nil != err {
+		return nil, err
+	}
+	if _, ok := v["discriminator"]; !ok {
+		return nil, nil
+	}
+
+	var layout Layout
+	err = json.Unmarshal([]byte(layoutAsString), &layout)
+	if nil != err {
+		return nil, err
+	}
+	if layout.Discriminator.Len <= 0 || 0 == len(layout.Records) {
+		return nil, fmt.Errorf("layout %s needs a discriminator len and at least one record", layoutPath)
+	}
+
+	for i, r := range layout.Records {
+		if len(r.Code) != layout.Discriminator.Len {
+			return nil, fmt.Errorf("record code %s does not match discriminator len %d", r.Code, layout.Discriminator.Len)
+		}
+		if "" == r.Name {
+			layout.Records[i].Name = r.Code
+		}
+		if !filepath.IsAbs(r.Schema) {
+			layout.Records[i].Schema = filepath.Join(filepath.Dir(layoutPath), r.Schema)
+		}
+	}
+	return &layout, nil
+}
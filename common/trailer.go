@@ -0,0 +1,71 @@
+/*
+ * MIT No Attribution
+ *
+ * Copyright 2021 Rickard Lundin (rickard@ignalina.dk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package common
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// TrailerField is a value in the trailer record, a record count or a hash total of Column
+type TrailerField struct {
+	Offset int
+	Len    int
+	Column string
+}
+
+// ParseTrailerField parses offset:len for a record count or offset:len:column for a hash total, offsets are in bytes.
+func ParseTrailerField(spec string, withColumn bool) (*TrailerField, error) {
+	if "" == spec {
+		return nil, nil
+	}
+
+	parts := strings.Split(spec, ":")
+	if (withColumn && 3 != len(parts)) || (!withColumn && 2 != len(parts)) {
+		if withColumn {
+			return nil, fmt.Errorf("trailer field %s should be offset:len:column", spec)
+		}
+		return nil, fmt.Errorf("trailer field %s should be offset:len", spec)
+	}
+
+	var tf TrailerField
+	var err error
+	tf.Offset, err = strconv.Atoi(parts[0])
+	if nil != err {
+		return nil, fmt.Errorf("trailer field %s has a bad offset", spec)
+	}
+	tf.Len, err = strconv.Atoi(parts[1])
+	if nil != err || tf.Len <= 0 {
+		return nil, fmt.Errorf("trailer field %s has a bad len", spec)
+	}
+	if withColumn {
+		tf.Column = parts[2]
+	}
+	return &tf, nil
+}
+
+// Value cuts the field out of the trailer record
+func (tf *TrailerField) Value(trailer string) (string, error) {
+	if len(trailer) < tf.Offset+tf.Len {
+		return "", fmt.Errorf("trailer record too short for field at %d:%d", tf.Offset, tf.Len)
+	}
+	return trailer[tf.Offset : tf.Offset+tf.Len], nil
+}
@@ -21,15 +21,17 @@ package fixed2avro
 
 import (
 	"bufio"
-	"bytes"
 	"encoding/binary"
 	"fmt"
 	"github.com/hamba/avro"
 	"github.com/ignalina/shredder/common"
+	"golang.org/x/text/encoding"
 	"io"
+	"log"
+	"math/big"
 	"os"
 	"reflect"
-	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -38,7 +40,15 @@ type TableChunk struct {
 	fstc           *common.FixedSizeTableChunk
 	Table          *Table
 	columnBuilders []ColumnBuilder
+	substring      []Substring
 	Exporter       ExportProducer
+	recordTypes    map[string]*TableChunk // Per record type chunks for multi record type files
+	sumIndex       int                    // Column of the trailer hash total, -1 if not summed
+	hashValue      big.Rat
+	arrays         []reflect.Value // Slice of each OCCURS array, invalid for skipped arrays
+	dependingOn    []int           // Arrays with OCCURS DEPENDING ON
+	derived        []derivedColumn
+	fields         []string // Fields of the current delimited record
 }
 
 type Table struct {
@@ -54,6 +64,7 @@ type ColumnBuilder interface {
 func (tb *TableChunk) CreateColumBuilders() bool {
 
 	tb.columnBuilders = make([]ColumnBuilder, len(tb.fstc.FixedSizeTable.Row.FixedField))
+	tb.substring = createSubstring(tb.fstc.FixedSizeTable)
 
 	var err error
 
@@ -67,8 +78,51 @@ func (tb *TableChunk) CreateColumBuilders() bool {
 	v := reflect.New(tb.fstc.FixedSizeTable.Row.RecordStruct).Elem()
 	tb.fstc.RecordStructInstance = v
 
-	for i, ff := range tb.fstc.FixedSizeTable.Row.FixedField {
-		tb.columnBuilders[i] = *CreateColumBuilder(i, &ff, ff.Len, &tb.fstc.RecordStructInstance)
+	// Arrays get all elements once, DEPENDING ON only changes the length. Outer arrays come first.
+	row := tb.fstc.FixedSizeTable.Row
+	tb.arrays = make([]reflect.Value, len(row.Arrays))
+	tb.dependingOn = nil
+	for i, a := range row.Arrays {
+		if nil != a.Path {
+			tb.arrays[i] = fieldValue(v, a.Path)
+			tb.arrays[i].Set(reflect.MakeSlice(tb.arrays[i].Type(), a.Occurs, a.Occurs))
+		}
+		if "" != a.DependingOn {
+			tb.dependingOn = append(tb.dependingOn, i)
+		}
+	}
+
+	for i := range row.FixedField {
+		ff := &row.FixedField[i]
+		if ff.Skip {
+			tb.columnBuilders[i] = ColumnBuilderSkip{}
+			continue
+		}
+		if ff.TopLevel() {
+			tb.columnBuilders[i] = *CreateColumBuilder(ff.FieldNr, ff, ff.Len, &tb.fstc.RecordStructInstance)
+			continue
+		}
+
+		// Fields of nested records and array elements
+		last := ff.Path[len(ff.Path)-1]
+		parent := fieldValue(v, ff.Path[:len(ff.Path)-1])
+		if last.Index < 0 {
+			tb.columnBuilders[i] = *CreateColumBuilder(last.FieldNr, ff, ff.Len, &parent)
+		} else {
+			tb.columnBuilders[i] = createElementColumnBuilder(ff, parent.Field(last.FieldNr).Index(last.Index))
+		}
+	}
+
+	tb.derived = nil
+	for i := range row.Derived {
+		if !row.Derived[i].Skip {
+			tb.derived = append(tb.derived, createDerivedColumn(&row.Derived[i], v))
+		}
+	}
+
+	tb.sumIndex = -1
+	if nil != tb.Table.Fst.TrailerSumField {
+		tb.sumIndex = columnIndex(tb.fstc.FixedSizeTable, tb.Table.Fst.TrailerSumField.Column)
 	}
 	return true
 }
@@ -96,27 +150,337 @@ func (tb *TableChunk) appendAvroBinary() error {
 
 // Read chunks of file and process them in go routine after each chunk read. Slow disk is non non zerocopy disk like sans etc
 func (t *Table) CreateFixedSizeTableFromSlowDisk(fileName string, args []string) error {
+	err := t.setup()
+	if nil != err {
+		return err
+	}
+
+	if streamInput(t.Fst, fileName) {
+		return StreamChunks(t, fileName, args)
+	}
+	return ParalizeChunks(t, fileName, args)
+}
+
+// Map the file into memory and let each chunk parse straight from the mapped pages, no copy and no read before the
+// parsing starts. Fast disk is nvme or local ssd where page faults keep up with the cores. Streams are streamed as from slow disk.
+func (t *Table) CreateFixedSizeTableFromFastDisk(fileName string, args []string) error {
+	err := t.setup()
+	if nil != err {
+		return err
+	}
+
+	if streamInput(t.Fst, fileName) {
+		return StreamChunks(t, fileName, args)
+	}
+	return MapChunks(t, fileName, args)
+}
+
+// setup loads the schema or layout and checks the options, before any chunk is read
+func (t *Table) setup() error {
 	var err error
 
 	t.Fst.SchemaAsString, err = common.ReadFileToString(t.Fst.SchemaFilePath)
-	t.Fst.Schema, err = common.CreateSchema(t.Fst.SchemaAsString)
 	if nil != err {
 		return err
 	}
-	t.Fst.BinarySchemaId = make([]byte, 4)
-	binary.BigEndian.PutUint32(t.Fst.BinarySchemaId, uint32(t.Fst.SchemaID))
-	if err != nil {
+
+	layout, err := common.ReadLayout(t.Fst.SchemaAsString, t.Fst.SchemaFilePath)
+	if nil != err {
+		return err
+	}
+	if nil != layout {
+		err = createRecordTypes(t.Fst, layout)
+	} else {
+		err = loadSchema(t.Fst)
+	}
+	if nil != err {
+		return err
+	}
+
+	t.Fst.InputEncoding, err = common.GetEncoding(t.Fst.Encoding)
+	if nil != err {
+		return err
+	}
+	if "" != t.Fst.Descriptor && "rdw" != t.Fst.Descriptor && "bdw" != t.Fst.Descriptor {
+		return fmt.Errorf("unknown descriptor %s, use rdw or bdw", t.Fst.Descriptor)
+	}
+	t.Fst.RecordTerminator, err = common.GetTerminator(t.Fst.Terminator)
+	if nil != err {
+		return err
+	}
+	t.Fst.Newline, err = common.EncodeTerminator(t.Fst.InputEncoding, t.Fst.RecordTerminator)
+	if nil != err {
 		return err
 	}
 
-	t.Fst.Row, err = common.CreateRowFromSchema(t.Fst.SchemaAsString)
+	err = setupHeaderTrailer(t.Fst)
+	if nil != err {
+		return err
+	}
+	err = setupDelimited(t.Fst)
+	if nil != err {
+		return err
+	}
+	err = checkColumns(t.Fst)
+	if nil != err {
+		return err
+	}
+	err = compileWhere(t.Fst)
 	if nil != err {
 		return err
 	}
 
+	// Without terminator or descriptor the record length is all there is to split on
+	if nil != layout && t.Fst.RawRecords() && "" == t.Fst.Descriptor {
+		for _, rt := range t.Fst.RecordTypes {
+			if rt.Row.DataLength() != t.Fst.DataLength() {
+				return fmt.Errorf("record type %s is %d bytes, fixed length record types must all be %d bytes", rt.Code, rt.Row.DataLength(), t.Fst.DataLength())
+			}
+		}
+	}
+	if t.Fst.RawRecords() && "" == t.Fst.Descriptor {
+		for _, rt := range append([]*common.FixedSizeTable{t.Fst}, t.Fst.RecordTypes...) {
+			if nil != rt.Row && rt.Row.DependingOn() {
+				return fmt.Errorf("occurs depending on needs records of varying length, a terminator without binary fields or a descriptor")
+			}
+		}
+	}
+
 	t.Fst.Wg = &sync.WaitGroup{}
-	return ParalizeChunks(t, fileName, args)
+	return nil
+}
+
+func loadSchema(fst *common.FixedSizeTable) error {
+	var err error
+
+	// The row needs all columns for their positions and parses the columns -where and -trailer-sum read,
+	// the output schema only has the projected ones
+	columns := fst.ColumnList()
+	layoutAsString, err := common.ProjectColumns(fst.SchemaAsString, append(readColumns(fst), columns...))
+	if nil != err {
+		return err
+	}
+	fst.Row, err = common.CreateRowFromSchema(layoutAsString)
+	if nil != err {
+		return err
+	}
+	if 0 != len(columns) || fst.Row.Skips() {
+		outputAsString, err := common.ProjectColumns(fst.SchemaAsString, columns)
+		if nil != err {
+			return err
+		}
+		fst.SchemaAsString, err = common.OutputSchema(outputAsString)
+		if nil != err {
+			return err
+		}
+		if fst.Verbose {
+			log.Println("Output schema =", fst.SchemaAsString)
+		}
+	}
+
+	fst.Schema, err = common.CreateSchema(fst.SchemaAsString)
+	if nil != err {
+		return err
+	}
+	fst.BinarySchemaId = make([]byte, 4)
+	binary.BigEndian.PutUint32(fst.BinarySchemaId, uint32(fst.SchemaID))
+	return nil
+}
+
+// readColumns are the columns the row filter and the hash total read, nil when all columns are output
+func readColumns(fst *common.FixedSizeTable) []string {
+	if "" == fst.Columns {
+		return nil
+	}
+	columns := common.ExpressionColumns(fst.Where)
+	if sum, _ := common.ParseTrailerField(fst.TrailerSum, true); nil != sum {
+		columns = append(columns, sum.Column)
+	}
+	return columns
+}
+
+// checkColumns fails on projected columns that are in no schema
+func checkColumns(fst *common.FixedSizeTable) error {
+	for _, c := range fst.ColumnList() {
+		found := false
+		for _, rt := range append([]*common.FixedSizeTable{fst}, fst.RecordTypes...) {
+			if nil == rt.Row {
+				continue
+			}
+			for i := 0; i < rt.Row.RecordStruct.NumField(); i++ {
+				if strings.EqualFold(rt.Row.RecordStruct.Field(i).Name, c) {
+					found = true
+				}
+			}
+		}
+		if !found {
+			return fmt.Errorf("column %s not found", c)
+		}
+	}
+	return nil
+}
+
+// setupDelimited checks the options of delimited input and maps the fields by position, fixed width layouts need len on every column
+func setupDelimited(fst *common.FixedSizeTable) error {
+	var err error
+	if "" == fst.Delimiter {
+		if fst.HeaderRow {
+			return fmt.Errorf("-header needs -delimiter")
+		}
+		for _, rt := range append([]*common.FixedSizeTable{fst}, fst.RecordTypes...) {
+			if nil != rt.Row {
+				err = rt.Row.CheckLengths()
+				if nil != err {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	if nil != fst.Discriminator || "" != fst.Descriptor || 0 == len(fst.RecordTerminator) {
+		return fmt.Errorf("delimited input needs one layout and a record terminator")
+	}
+	if fst.Row.Binary || fst.Row.DependingOn() {
+		return fmt.Errorf("delimited input can not have binary fields or occurs depending on")
+	}
+	fst.Delimited, err = common.NewDelimited(fst.Delimiter, fst.Quote, fst.Escape, fst.HeaderRow)
+	if nil != err {
+		return err
+	}
+	fst.Delimited.MapFields(fst.Row)
+	return nil
+}
+
+// readHeaderRow maps the fields by the names in the first record after the header lines, the record is then skipped as a header line
+func readHeaderRow(fst *common.FixedSizeTable, buf []byte) error {
+	var err error
+	d := fst.Delimited
+	if nil != fst.InputEncoding {
+		buf, err = fst.InputEncoding.NewDecoder().Bytes(buf)
+		if nil != err {
+			return err
+		}
+	}
+
+	for i := 0; i <= fst.HeaderLines; i++ {
+		n := common.RecordEnd(buf, fst.RecordTerminator, d.Quote, d.Escape)
+		if n < 0 {
+			return fmt.Errorf("no header record in the first chunk")
+		}
+		if i == fst.HeaderLines {
+			err = d.MapHeader(fst.Row, d.Split(string(buf[:n-len(fst.RecordTerminator)]), nil))
+			if nil != err {
+				return err
+			}
+		}
+		buf = buf[n:]
+	}
+	fst.HeaderLines++
+	return nil
+}
+
+// compileWhere compiles the row filter of the table, or of each record type. Record types that do not have the
+// columns of the filter are not filtered.
+func compileWhere(fst *common.FixedSizeTable) error {
+	var err error
+	if "" == fst.Where {
+		return nil
+	}
+	if nil == fst.Discriminator {
+		fst.Filter, err = fst.Row.CompileFilter(fst.Where)
+		if nil != err {
+			return fmt.Errorf("where %s: %v", fst.Where, err)
+		}
+		return nil
+	}
+
+	filtered := false
+	for _, rt := range fst.RecordTypes {
+		rt.Filter, err = rt.Row.CompileFilter(fst.Where)
+		if nil != err {
+			if fst.Verbose {
+				log.Println("record type", rt.Code, "is not filtered:", err)
+			}
+			continue
+		}
+		filtered = true
+	}
+	if !filtered {
+		return fmt.Errorf("where %s: %v", fst.Where, err)
+	}
+	return nil
+}
+
+// createRecordTypes makes one table per record type in the layout, each with its own schema and output
+func createRecordTypes(fst *common.FixedSizeTable, layout *common.Layout) error {
+	var err error
+
+	fst.Discriminator = &layout.Discriminator
+	fst.RecordTypes = make([]*common.FixedSizeTable, len(layout.Records))
+
+	for i, r := range layout.Records {
+		rt := &common.FixedSizeTable{
+			Args:           fst.Args,
+			Schemaregistry: fst.Schemaregistry,
+			SchemaFilePath: r.Schema,
+			SchemaID:       r.SchemaID,
+			Cores:          fst.Cores,
+			Code:           r.Code,
+			Name:           r.Name,
+			Topic:          r.Topic,
+			Columns:        fst.Columns,
+			Where:          fst.Where,
+			TrailerSum:     fst.TrailerSum,
+			Verbose:        fst.Verbose,
+			TableChunks:    make([]common.FixedSizeTableChunk, fst.Cores),
+		}
+		rt.SchemaAsString, err = common.ReadFileToString(rt.SchemaFilePath)
+		if nil != err {
+			return err
+		}
+		err = loadSchema(rt)
+		if nil != err {
+			return fmt.Errorf("record type %s: %s", r.Code, err.Error())
+		}
+		fst.RecordTypes[i] = rt
+	}
+	return nil
+}
+
+// createChunk sets up the exporter and column builders for a chunk, or for each record type in a multi record type file
+func (t *Table) createChunk(chunkNr int, args []string) {
+	t.Fst.TableChunks[chunkNr] = common.FixedSizeTableChunk{FixedSizeTable: t.Fst, Chunkr: chunkNr}
+	t.TableChunks[chunkNr] = TableChunk{fstc: &t.Fst.TableChunks[chunkNr], Table: t}
 
+	if nil == t.Fst.Discriminator {
+		t.TableChunks[chunkNr].Exporter = *ExportersFactory(args, &t.Fst.TableChunks[chunkNr])
+		t.TableChunks[chunkNr].CreateColumBuilders()
+		return
+	}
+
+	t.TableChunks[chunkNr].recordTypes = make(map[string]*TableChunk)
+	for _, rt := range t.Fst.RecordTypes {
+		rt.TableChunks[chunkNr] = common.FixedSizeTableChunk{FixedSizeTable: rt, Chunkr: chunkNr}
+		rtc := &TableChunk{fstc: &rt.TableChunks[chunkNr], Table: t}
+		rtc.Exporter = *ExportersFactory(args, rtc.fstc)
+		rtc.CreateColumBuilders()
+		t.TableChunks[chunkNr].recordTypes[rt.Code] = rtc
+	}
+}
+
+// finish waits for the export of the chunk, or of every record type in it
+func (tb *TableChunk) finish() error {
+	if nil == tb.recordTypes {
+		return tb.Exporter.Finish()
+	}
+	for _, rtc := range tb.recordTypes {
+		err := rtc.Exporter.Finish()
+		if nil != err {
+			return err
+		}
+	}
+	return nil
 }
 
 func ParalizeChunks(t *Table, filename string, args []string) error {
@@ -129,12 +493,27 @@ func ParalizeChunks(t *Table, filename string, args []string) error {
 	defer file.Close()
 	fi, _ := file.Stat()
 
+	// Compressed files are decompressed in memory first, the chunks are then cut from the decompressed bytes
+	decompressed, err := readCompressed(t.Fst, file, filename)
+	if nil != err {
+		return err
+	}
+	if nil != decompressed {
+		t.Fst.Bytes = decompressed
+		return t.processChunks(nil, args)
+	}
 	t.Fst.Bytes = make([]byte, fi.Size())
+	return t.processChunks(file, args)
+}
+
+// processChunks cuts Bytes in a chunk per core and processes each chunk, after reading it from file unless file is nil
+func (t *Table) processChunks(file io.Reader, args []string) error {
+	var err error
 	t.Fst.TableChunks = make([]common.FixedSizeTableChunk, t.Fst.Cores)
 	t.TableChunks = make([]TableChunk, t.Fst.Cores)
 
-	chunkSize := fi.Size() / int64(t.Fst.Cores)
-	rowlength := int64(t.Fst.Row.CalRowLength())
+	chunkSize := int64(len(t.Fst.Bytes)) / int64(t.Fst.Cores)
+	rowlength := int64(t.Fst.DataLength() + len(t.Fst.Newline))
 
 	if chunkSize < int64(rowlength) {
 		chunkSize = int64(rowlength)
@@ -145,27 +524,63 @@ func ParalizeChunks(t *Table, filename string, args []string) error {
 	p1 := 0
 	p2 := 0
 
+	// Quote and escape as they are in the file, chunks are cut before decoding
+	var quote, escape byte
+	if nil != t.Fst.Delimited {
+		quote, escape, err = encodedQuotes(t.Fst)
+		if nil != err {
+			return err
+		}
+	}
+
 	for goon {
 
-		t.Fst.TableChunks[chunkNr] = common.FixedSizeTableChunk{FixedSizeTable: t.Fst, Chunkr: chunkNr}
-		t.TableChunks[chunkNr] = TableChunk{fstc: &t.Fst.TableChunks[chunkNr], Table: t}
-		t.TableChunks[chunkNr].Exporter = *ExportersFactory(os.Args, &t.Fst.TableChunks[chunkNr])
-		t.TableChunks[chunkNr].CreateColumBuilders()
+		t.createChunk(chunkNr, args)
 
 		i1 := int(chunkSize) * chunkNr
 		i2 := int(chunkSize) * (chunkNr + 1)
-		if chunkNr == (t.Fst.Cores - 1) {
+		if chunkNr == (t.Fst.Cores-1) || i2 > len(t.Fst.Bytes) {
 			i2 = len(t.Fst.Bytes)
 		}
 		buf := t.Fst.Bytes[i1:i2]
 		startReadChunk := time.Now()
-		nread, _ := io.ReadFull(file, buf)
+		nread := len(buf)
+		if nil != file {
+			nread, _ = io.ReadFull(file, buf)
+		}
 		t.Fst.TableChunks[chunkNr].DurationReadChunk = time.Since(startReadChunk)
 		buf = buf[:nread]
 		goon = i2 < len(t.Fst.Bytes)
-		p2 = i1 + common.FindLastNL(buf)
+		if 0 == chunkNr && nil != t.Fst.Delimited && t.Fst.Delimited.Header {
+			err = readHeaderRow(t.Fst, buf)
+			if nil != err {
+				return err
+			}
+		}
+		if "" != t.Fst.Descriptor {
+			// Sequential index pass, continues from the end of the previous chunk
+			p2 = common.FindLastDescriptor(t.Fst.Bytes, p1, i1+nread)
+		} else if nil != t.Fst.Delimited && goon {
+			// Quoted fields may hold record terminators, the cut is made outside quotes
+			p2 = common.FindLastDelimitedRecord(t.Fst.Bytes, p1, i1+nread, t.Fst.Newline, quote, escape)
+		} else if nil != t.Fst.Delimited {
+			p2 = i1 + nread
+		} else if !t.Fst.RawRecords() && goon {
+			p2 = i1 + common.FindLastNL(buf, t.Fst.Newline)
+		} else if goon {
+			// Cut on whole records, binary fields may contain newline bytes
+			recordLength := int(rowlength)
+			p2 = (i1 + nread) / recordLength * recordLength
+		} else {
+			p2 = i1 + nread
+		}
+		if p2 < p1 {
+			p2 = p1
+		}
 
 		t.Fst.TableChunks[chunkNr].Bytes = t.Fst.Bytes[p1:p2]
+		t.Fst.TableChunks[chunkNr].First = 0 == chunkNr
+		t.Fst.TableChunks[chunkNr].Last = !goon
 		p1 = p2
 		t.Fst.Wg.Add(1)
 		t.TableChunks[chunkNr].process()
@@ -174,212 +589,317 @@ func ParalizeChunks(t *Table, filename string, args []string) error {
 
 	t.Fst.Wg.Wait() // Waiting for ALL pararell routes to finish
 
+	return t.finishChunks()
+}
+
+// finishChunks sums up the chunks, waits for the exporters, reports rejected records and reconciles the trailer
+func (t *Table) finishChunks() error {
 	// Sum up some statitics
+	var rejected error
 	for _, tableChunk := range t.Fst.TableChunks {
 		t.Fst.DurationToAvro += tableChunk.DurationToAvro
 		t.Fst.DurationReadChunk += tableChunk.DurationReadChunk
 		t.Fst.DurationToExport += tableChunk.DurationToExport
 		t.Fst.LinesParsed += tableChunk.LinesParsed
+		t.Fst.LinesFiltered += tableChunk.LinesFiltered
+		t.Fst.LinesRejected += tableChunk.LinesRejected
+		t.Fst.ShortRecords += tableChunk.ShortRecords
+		for code, n := range tableChunk.UnknownTypes {
+			if nil == t.Fst.UnknownTypes {
+				t.Fst.UnknownTypes = make(map[string]int)
+			}
+			t.Fst.UnknownTypes[code] += n
+		}
+		if nil == rejected {
+			rejected = tableChunk.Rejected
+		}
+	}
+	for _, rt := range t.Fst.RecordTypes {
+		for _, tableChunk := range rt.TableChunks {
+			rt.LinesParsed += tableChunk.LinesParsed
+			rt.LinesFiltered += tableChunk.LinesFiltered
+		}
+		t.Fst.LinesFiltered += rt.LinesFiltered
 	}
 
+	// Every exporter is finished before the trailer is checked, so no output file is left unreadable
 	startWaitDoneExport := time.Now()
 
+	var err error
 	for i, _ := range t.Fst.TableChunks {
-		err := t.TableChunks[i].Exporter.Finish()
-		if nil != err {
-			return err
+		// A small file can have fewer chunks than cores
+		if nil == t.TableChunks[i].fstc {
+			continue
+		}
+		if finishErr := t.TableChunks[i].finish(); nil == err {
+			err = finishErr
 		}
 	}
 	t.Fst.DurationDoneExport = time.Since(startWaitDoneExport)
+	if nil != err {
+		return err
+	}
+	if nil != rejected {
+		return fmt.Errorf("%d records rejected, first %w", t.Fst.LinesRejected, rejected)
+	}
 
-	return nil
+	return reconcileTrailer(t.Fst)
 }
 
 func (tb *TableChunk) process() {
 	startToAvro := time.Now()
 	defer tb.fstc.FixedSizeTable.Wg.Done()
-	re := bytes.NewReader(tb.fstc.Bytes)
-	//	decodingReader := transform.NewReader(re, charmap.ISO8859_1.NewDecoder())
-
-	scanner := bufio.NewScanner(re)
-
-	substring := createSubstring(tb.fstc.FixedSizeTable)
-
+	fst := tb.fstc.FixedSizeTable
+	chunkBytes := tb.fstc.Bytes
+
+	var textDecoder *encoding.Decoder
+	if nil != fst.InputEncoding {
+		textDecoder = fst.InputEncoding.NewDecoder()
+	}
+
+	// Single byte code pages are decoded to utf8 per chunk, so each core pays for its own part.
+	// Fixed length records and byte widths are kept raw and only the text fields are decoded.
+	rawRecords := fst.RawRecords() || (nil != textDecoder && fst.ByteWidths() && nil == fst.Delimited)
+	if nil != textDecoder && !rawRecords {
+		var err error
+		chunkBytes, err = textDecoder.Bytes(chunkBytes)
+		if IsError(err) {
+			return
+		}
+	}
+	var split bufio.SplitFunc
+	if "" != fst.Descriptor {
+		split = scanVariableRecords("bdw" == fst.Descriptor)
+	} else if nil != fst.Delimited {
+		split = scanDelimitedRecords(fst.RecordTerminator, fst.Delimited.Quote, fst.Delimited.Escape)
+	} else if fst.RawRecords() {
+		split = scanFixedRecords(fst.DataLength(), len(fst.Newline))
+	} else if rawRecords {
+		split = scanTerminatedRecords(fst.Newline)
+	} else {
+		split = scanTerminatedRecords(fst.RecordTerminator)
+	}
+	// The records are split in place, a chunk of a mapped file is parsed straight from the mapped pages
+	scanner := newRecordScanner(chunkBytes, split)
+
+	// Header is only in the first chunk and the trailer lines only in the last one
+	headerLeft := 0
+	inHeader := false
+	if tb.fstc.First {
+		headerLeft = fst.HeaderLines
+		inHeader = nil != fst.HeaderRegexp
+	}
+	trailerPrefix := fst.TrailerPrefix
+	if rawRecords {
+		trailerPrefix = fst.TrailerRawPrefix
+	}
+	var trailerLines []string
+	holdBack := 0
+	if tb.fstc.Last {
+		holdBack = fst.TrailerLines
+	}
+
+	// A streamed chunk is processed once for each block it gets
+	linesBefore := tb.fstc.LinesParsed
 	lineCnt := 0
 	for scanner.Scan() {
 		line := scanner.Text()
-		if len(line) > 12 && line[:12] == "************" {
-			fmt.Println("skipping footer")
+		if headerLeft > 0 {
+			headerLeft--
+			continue
+		}
+		if inHeader {
+			if fst.HeaderRegexp.MatchString(tb.recordText(line, rawRecords, textDecoder)) {
+				continue
+			}
+			inHeader = false
+		}
+		if nil != fst.TrailerRegexp && strings.HasPrefix(line, trailerPrefix) && fst.TrailerRegexp.MatchString(tb.recordText(line, rawRecords, textDecoder)) {
+			fmt.Println("skipping trailer")
+			tb.fstc.Trailer = tb.recordText(line, rawRecords, textDecoder)
 			break
 		}
+		// The last lines are held back until it is known they are not the trailer
+		if holdBack > 0 {
+			trailerLines = append(trailerLines, line)
+			if len(trailerLines) <= holdBack {
+				continue
+			}
+			line = trailerLines[0]
+			trailerLines = trailerLines[1:]
+		}
 		lineCnt++
 
-		getSplitBytePositions(line, substring)
-
-		for ci, _ := range tb.fstc.FixedSizeTable.Row.FixedField {
-			tb.columnBuilders[ci].ParseValue(substring[ci].sub)
+		recordChunk := tb
+		if nil != fst.Discriminator {
+			recordChunk = tb.recordTypeChunk(line, rawRecords, textDecoder)
+			if nil == recordChunk {
+				continue
+			}
+		}
+		err := recordChunk.parseRecord(line, rawRecords, textDecoder)
+		if nil != err {
+			tb.fstc.LinesRejected++
+			if nil == tb.fstc.Rejected {
+				tb.fstc.Rejected = fmt.Errorf("chunk %d record %d: %w", tb.fstc.Chunkr, linesBefore+lineCnt, err)
+			}
 		}
-		tb.Exporter.ExportRow()
-	}
-	tb.fstc.LinesParsed = lineCnt
-	tb.fstc.DurationToAvro = time.Since(startToAvro)
-
-}
-
-var lo = &time.Location{}
-
-// 2020-07-09-09.59.59.99375
-func DateStringT1ToUnix_millisecond(dateString string) (int64, error) {
-
-	var year64, month64, day64, hour64, minute64, second64, nanoSec64 int64
-	var err error
-
-	year64, err = strconv.ParseInt(dateString[:4], 10, 32)
-
-	if nil != err {
-		return 0, err
 	}
-
-	month64, err = strconv.ParseInt(dateString[5:7], 10, 8)
-
-	if nil != err {
-		return 0, err
+	IsError(scanner.Err())
+	if 0 != len(trailerLines) && "" == tb.fstc.Trailer {
+		tb.fstc.Trailer = tb.recordText(trailerLines[0], rawRecords, textDecoder)
 	}
+	tb.fstc.LinesParsed = linesBefore + lineCnt
+	tb.fstc.DurationToAvro += time.Since(startToAvro)
 
-	day64, err = strconv.ParseInt(dateString[8:10], 10, 8)
-	if nil != err {
-		return 0, err
-	}
+}
 
-	hour64, err = strconv.ParseInt(dateString[11:13], 10, 8)
-	if nil != err {
-		return 0, err
-	}
-
-	minute64, err = strconv.ParseInt(dateString[14:16], 10, 8)
-	if nil != err {
-		return 0, err
+// recordText returns the record as utf8 text, raw records are decoded when an input encoding is set
+func (tb *TableChunk) recordText(line string, rawRecords bool, textDecoder *encoding.Decoder) string {
+	if rawRecords && nil != textDecoder {
+		line, _ = textDecoder.String(line)
 	}
+	return line
+}
 
-	second64, err = strconv.ParseInt(dateString[17:19], 10, 8)
-	if nil != err {
-		return 0, err
+// recordTypeChunk picks the record type from the discriminator, nil for unknown codes and records too short for it, they are counted
+func (tb *TableChunk) recordTypeChunk(line string, rawRecords bool, textDecoder *encoding.Decoder) *TableChunk {
+	d := tb.fstc.FixedSizeTable.Discriminator
+	if len(line) < d.Offset+d.Len {
+		tb.fstc.ShortRecords++
+		return nil
 	}
+	code := tb.recordText(line[d.Offset:d.Offset+d.Len], rawRecords, textDecoder)
 
-	nanoSec64, err = strconv.ParseInt(dateString[20:23], 10, 8)
-	nanoSec64 = nanoSec64 * 1000000
-	if nil != err {
-		return 0, err
+	rtc, ok := tb.recordTypes[code]
+	if !ok {
+		if nil == tb.fstc.UnknownTypes {
+			tb.fstc.UnknownTypes = make(map[string]int)
+		}
+		tb.fstc.UnknownTypes[code]++
+		return nil
 	}
-
-	var ti time.Time
-
-	ti = time.Date(int(year64), time.Month(month64), int(day64), int(hour64), int(minute64), int(second64), int(nanoSec64), lo)
-
-	return ti.Unix(), nil
-
+	return rtc
 }
 
-// 2020-07-09-09.59.59.99375
-func DateStringT1ToUnix_microsecond(dateString string) (int64, error) {
+// parseRecord runs the column builders on one record and exports it. A record with a value that does not parse is not exported.
+func (tb *TableChunk) parseRecord(line string, rawRecords bool, textDecoder *encoding.Decoder) error {
+	row := tb.fstc.FixedSizeTable.Row
 
-	var year64, month64, day64, hour64, minute64, second64, nanoSec64 int64
-	var err error
+	tb.splitRecord(line, rawRecords, textDecoder)
 
-	year64, err = strconv.ParseInt(dateString[:4], 10, 32)
-
-	if nil != err {
-		return 0, err
-	}
-
-	month64, err = strconv.ParseInt(dateString[5:7], 10, 8)
-
-	if nil != err {
-		return 0, err
+	// Absent elements of OCCURS DEPENDING ON take no room, the columns after them move up
+	for _, ai := range tb.dependingOn {
+		a := &row.Arrays[ai]
+		count, err := countValue(&row.FixedField[a.CountLeaf], tb.substring[a.CountLeaf].sub)
+		if nil != err || count > a.Occurs {
+			count = a.Occurs
+		}
+		if tb.presentElements(a, count) {
+			tb.splitRecord(line, rawRecords, textDecoder)
+		}
+		if tb.arrays[ai].IsValid() {
+			tb.arrays[ai].SetLen(count)
+		}
 	}
 
-	day64, err = strconv.ParseInt(dateString[8:10], 10, 8)
-	if nil != err {
-		return 0, err
+	for ci, ff := range row.FixedField {
+		if 0 == tb.substring[ci].width && 0 != ff.Len {
+			continue
+		}
+		if !tb.columnBuilders[ci].ParseValue(tb.substring[ci].sub) {
+			tb.fstc.LinesParsed++
+			return fmt.Errorf("invalid %s value %q in column %s", ff.ColumnType, tb.substring[ci].sub, ff.Name)
+		}
 	}
-
-	hour64, err = strconv.ParseInt(dateString[11:13], 10, 8)
-	if nil != err {
-		return 0, err
+	for i := range tb.derived {
+		tb.derived[i].evaluate(tb.fstc.RecordStructInstance)
 	}
-
-	minute64, err = strconv.ParseInt(dateString[14:16], 10, 8)
-	if nil != err {
-		return 0, err
+	if tb.sumIndex >= 0 {
+		tb.addToHashTotal()
 	}
+	tb.fstc.LinesParsed++
 
-	second64, err = strconv.ParseInt(dateString[17:19], 10, 8)
-	if nil != err {
-		return 0, err
+	// Rows where the filter is false or null are parsed but not output
+	filter := tb.fstc.FixedSizeTable.Filter
+	if nil != filter {
+		keep := filter.Eval(tb.fstc.RecordStructInstance)
+		if common.ExprBool != keep.Kind || !keep.Bool {
+			tb.fstc.LinesFiltered++
+			return nil
+		}
 	}
-
-	nanoSec64, err = strconv.ParseInt(dateString[20:26], 10, 32)
+	err := tb.Exporter.ExportRow()
 	if nil != err {
-		return 0, err
+		return fmt.Errorf("export: %w", err)
 	}
-
-	var ti time.Time
-
-	ti = time.Date(int(year64), time.Month(month64), int(day64), int(hour64), int(minute64), int(second64), int(nanoSec64), lo)
-
-	return ti.Unix(), nil
-
+	return nil
 }
 
-func DateStringT1ToUnix_nanosecond(dateString string) (int64, error) {
-
-	var year64, month64, day64, hour64, minute64, second64, nanoSec64 int64
-	var err error
-
-	year64, err = strconv.ParseInt(dateString[:4], 10, 32)
-
-	if nil != err {
-		return 0, err
-	}
-
-	month64, err = strconv.ParseInt(dateString[5:7], 10, 8)
-
-	if nil != err {
-		return 0, err
-	}
-
-	day64, err = strconv.ParseInt(dateString[8:10], 10, 8)
-	if nil != err {
-		return 0, err
+// presentElements gives the first count elements of the array their width and the others none, true if a width changed
+func (tb *TableChunk) presentElements(a *common.FixedArray, count int) bool {
+	changed := false
+	present := a.FirstLeaf + count*(a.Leaves/a.Occurs)
+	for ci := a.FirstLeaf; ci < a.FirstLeaf+a.Leaves; ci++ {
+		width := 0
+		if ci < present {
+			width = tb.fstc.FixedSizeTable.Row.FixedField[ci].Len
+		}
+		if width != tb.substring[ci].width {
+			tb.substring[ci].width = width
+			changed = true
+		}
 	}
+	return changed
+}
 
-	hour64, err = strconv.ParseInt(dateString[11:13], 10, 8)
-	if nil != err {
-		return 0, err
+// splitRecord cuts the record into the columns
+func (tb *TableChunk) splitRecord(line string, rawRecords bool, textDecoder *encoding.Decoder) {
+	row := tb.fstc.FixedSizeTable.Row
+
+	if d := tb.fstc.FixedSizeTable.Delimited; nil != d {
+		tb.fields = d.Split(line, tb.fields)
+		for ci, fi := range d.FieldIndex {
+			tb.substring[ci].sub = ""
+			if fi >= 0 && fi < len(tb.fields) {
+				tb.substring[ci].sub = tb.fields[fi]
+			}
+		}
+		return
 	}
 
-	minute64, err = strconv.ParseInt(dateString[14:16], 10, 8)
-	if nil != err {
-		return 0, err
+	if rawRecords {
+		getSplitFixedPositions(line, tb.substring)
+		for ci, ff := range row.FixedField {
+			if nil != textDecoder && !ff.IsBinary() && !ff.Skip {
+				tb.substring[ci].sub, _ = textDecoder.String(tb.substring[ci].sub)
+			}
+		}
+	} else if row.ByteWidths {
+		// Fast path, no rune counting
+		getSplitFixedPositions(line, tb.substring)
+	} else {
+		getSplitBytePositions(line, tb.substring)
 	}
+}
 
-	second64, err = strconv.ParseInt(dateString[17:19], 10, 8)
-	if nil != err {
-		return 0, err
+// fieldValue follows the path from the record struct
+func fieldValue(v reflect.Value, path []common.FieldStep) reflect.Value {
+	for _, step := range path {
+		v = v.Field(step.FieldNr)
+		if step.Index >= 0 {
+			v = v.Index(step.Index)
+		}
 	}
+	return v
+}
 
-	nanoSec64, err = strconv.ParseInt(dateString[20:29], 10, 8)
-
-	if nil != err {
-		return 0, err
+// DateTimeToTime places the parsed date fields in the time zone loc, an offset carried by the value wins
+func DateTimeToTime(dt common.DateTime, loc *time.Location) time.Time {
+	if dt.HasOffset {
+		loc = time.FixedZone("", dt.Offset)
 	}
-
-	var ti time.Time
-
-	ti = time.Date(int(year64), time.Month(month64), int(day64), int(hour64), int(minute64), int(second64), int(nanoSec64), lo)
-
-	return ti.Unix(), nil
-
+	return time.Date(dt.Year, time.Month(dt.Month), dt.Day, dt.Hour, dt.Minute, dt.Second, dt.Nanosecond, loc)
 }
 
 func IsError(err error) bool {
@@ -390,15 +910,54 @@ func IsError(err error) bool {
 }
 
 func CreateColumBuilder(fieldnr int, fixedField *common.FixedField, columnsize int, recordStructInstance *reflect.Value) *ColumnBuilder {
+	var result ColumnBuilder
+	if fixedField.Nullable {
+		result = createNullableColumnBuilder(fieldnr, fixedField, columnsize, recordStructInstance)
+	} else {
+		result = *createValueColumnBuilder(fieldnr, fixedField, columnsize, recordStructInstance)
+	}
+
+	// Padding goes before null rules and type conversion
+	if "none" != fixedField.Trim && "" != fixedField.Trim {
+		result = createTrimColumnBuilder(fixedField, result)
+	}
+	return &result
+}
+
+func createValueColumnBuilder(fieldnr int, fixedField *common.FixedField, columnsize int, recordStructInstance *reflect.Value) *ColumnBuilder {
 	var result ColumnBuilder
 	columnsize = 0
 	//	columnsizeCap := 3000000
 
+	// Binary storage decides the parser, the column type only decides the Avro representation
+	switch fixedField.Usage {
+	case "comp-3":
+		result = &ColumnBuilderPacked{fixedField: fixedField, fieldnr: fieldnr, recordStructInstance: recordStructInstance}
+		return &result
+	case "comp":
+		result = &ColumnBuilderBinary{fixedField: fixedField, fieldnr: fieldnr, recordStructInstance: recordStructInstance}
+		return &result
+	case "comp-1", "comp-2":
+		result = &ColumnBuilderBinaryFloat{fixedField: fixedField, fieldnr: fieldnr, recordStructInstance: recordStructInstance}
+		return &result
+	case "":
+		if "" != fixedField.Sign {
+			result = &ColumnBuilderZoned{fixedField: fixedField, fieldnr: fieldnr, recordStructInstance: recordStructInstance}
+			return &result
+		}
+	}
+
 	switch fixedField.ColumnType {
 	case "boolean":
 		result = &ColumnBuilderBoolean{fixedField: fixedField, fieldnr: fieldnr, recordStructInstance: recordStructInstance}
-	case "Bytes":
+	case "bytes":
 		result = &ColumnBuilderBytes{fixedField: fixedField, fieldnr: fieldnr, recordStructInstance: recordStructInstance}
+	case "fixed":
+		result = &ColumnBuilderFixed{fixedField: fixedField, fieldnr: fieldnr, recordStructInstance: recordStructInstance}
+	case "enum":
+		result = &ColumnBuilderEnum{fixedField: fixedField, fieldnr: fieldnr, recordStructInstance: recordStructInstance}
+	case "uuid":
+		result = &ColumnBuilderUuid{fixedField: fixedField, fieldnr: fieldnr, recordStructInstance: recordStructInstance}
 	case "float":
 		result = &ColumnBuilderFloat{fixedField: fixedField, fieldnr: fieldnr, recordStructInstance: recordStructInstance}
 	case "double":
@@ -412,13 +971,23 @@ func CreateColumBuilder(fieldnr int, fixedField *common.FixedField, columnsize i
 		result = &ColumnBuilderLong{fixedField: fixedField, fieldnr: fieldnr, recordStructInstance: recordStructInstance}
 	case "date":
 		result = &ColumnBuilderDate{fixedField: fixedField, fieldnr: fieldnr, recordStructInstance: recordStructInstance}
+	case "time-millis":
+		result = &ColumnBuilderTimeMillis{fixedField: fixedField, fieldnr: fieldnr, recordStructInstance: recordStructInstance}
+	case "time-micros":
+		result = &ColumnBuilderTimeMicros{fixedField: fixedField, fieldnr: fieldnr, recordStructInstance: recordStructInstance}
+	case "local-timestamp-millis":
+		result = &ColumnBuilderLocalTimestampMillis{fixedField: fixedField, fieldnr: fieldnr, recordStructInstance: recordStructInstance}
+	case "local-timestamp-micros":
+		result = &ColumnBuilderLocalTimestampMicros{fixedField: fixedField, fieldnr: fieldnr, recordStructInstance: recordStructInstance}
 	case "timestamp-millis":
 		result = &ColumnBuilderTimestapMillis{fixedField: fixedField, fieldnr: fieldnr, recordStructInstance: recordStructInstance}
 	case "timestamp-micros":
 		result = &ColumnBuilderTimestapMicros{fixedField: fixedField, fieldnr: fieldnr, recordStructInstance: recordStructInstance}
+	case "decimal":
+		result = &ColumnBuilderDecimal{fixedField: fixedField, fieldnr: fieldnr, recordStructInstance: recordStructInstance}
 
 	default:
-		fmt.Printf("Unknown type ", fixedField.ColumnType)
+		fmt.Printf("Unknown type %s\n", fixedField.ColumnType)
 
 	}
 
@@ -20,9 +20,15 @@
 package fixed2avro
 
 import (
+	"encoding/base64"
+	"encoding/hex"
 	"github.com/ignalina/shredder/common"
+	"math"
+	"math/big"
 	"reflect"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type ColumnBuilderBoolean struct {
@@ -31,58 +37,162 @@ type ColumnBuilderBoolean struct {
 	recordStructInstance *reflect.Value
 }
 
-// make configurable
+// Unknown tokens do not parse
 func (c *ColumnBuilderBoolean) ParseValue(name string) bool {
-	boolChar := name[0]
-	var ourBool bool
+	if c.isToken(name, c.fixedField.TrueValues) {
+		c.recordStructInstance.Field(c.fieldnr).SetBool(true)
+		return true
+	}
+	c.recordStructInstance.Field(c.fieldnr).SetBool(false)
+	return c.isToken(name, c.fixedField.FalseValues)
+}
+
+func (c *ColumnBuilderBoolean) isToken(name string, tokens []string) bool {
+	for _, t := range tokens {
+		if t == name || (!c.fixedField.CaseSensitive && strings.EqualFold(t, name)) {
+			return true
+		}
+	}
+	return false
+}
 
-	switch boolChar {
-	case uint8('J'):
-		ourBool = true
-		break
+func (c *ColumnBuilderBoolean) FinishColumn() bool {
+	return true
+}
 
-	case uint8('j'):
-		ourBool = true
-		break
+type ColumnBuilderBytes struct {
+	fixedField           *common.FixedField
+	fieldnr              int
+	recordStructInstance *reflect.Value
+}
+
+func (c ColumnBuilderBytes) ParseValue(name string) bool {
+	value, err := decodeBytes(c.fixedField, name)
+	c.recordStructInstance.Field(c.fieldnr).SetBytes(value)
+	return (nil == err)
+}
 
-	case uint8('Y'):
-		ourBool = true
-		break
-	case uint8('y'):
-		ourBool = true
-		break
+func (c ColumnBuilderBytes) FinishColumn() bool {
+	return true
+}
 
-	case uint8('N'):
-		ourBool = false
-		break
+type ColumnBuilderFixed struct {
+	fixedField           *common.FixedField
+	fieldnr              int
+	recordStructInstance *reflect.Value
+}
 
-	case uint8('n'):
-		ourBool = false
-		break
+// Values decoding to another length than the size are zero
+func (c ColumnBuilderFixed) ParseValue(name string) bool {
+	field := c.recordStructInstance.Field(c.fieldnr)
+	value, err := decodeBytes(c.fixedField, name)
+	if nil != err || len(value) != c.fixedField.Size {
+		field.Set(reflect.Zero(field.Type()))
+		return false
 	}
-	c.recordStructInstance.Field(c.fieldnr).SetBool(ourBool)
+	reflect.Copy(field, reflect.ValueOf(value))
+	return true
+}
 
+func (c ColumnBuilderFixed) FinishColumn() bool {
 	return true
 }
-func (c *ColumnBuilderBoolean) FinishColumn() bool {
+
+// decodeBytes returns the bytes of a bytes or fixed column written as raw, hex or base64. hex and base64 may be padded with spaces.
+func decodeBytes(fixedField *common.FixedField, name string) ([]byte, error) {
+	switch fixedField.BytesFormat {
+	case "hex":
+		return hex.DecodeString(strings.TrimSpace(name))
+	case "base64":
+		return base64.StdEncoding.DecodeString(strings.TrimSpace(name))
+	}
+	return []byte(name), nil
+}
+
+type ColumnBuilderEnum struct {
+	fixedField           *common.FixedField
+	fieldnr              int
+	recordStructInstance *reflect.Value
+}
+
+// The value is a code mapped to a symbol or the symbol itself. Unknown values are the enum default, null for nullable columns
+func (c *ColumnBuilderEnum) ParseValue(name string) bool {
+	field := c.recordStructInstance.Field(c.fieldnr)
+	if symbol, ok := c.fixedField.Codes[name]; ok {
+		field.SetString(symbol)
+		return true
+	}
+	if c.fixedField.IsSymbol(name) {
+		field.SetString(name)
+		return true
+	}
+	field.SetString(c.fixedField.EnumDefault)
+	return !c.fixedField.Nullable
+}
+
+func (c *ColumnBuilderEnum) FinishColumn() bool {
 	return true
 }
 
-type ColumnBuilderBytes struct {
+type ColumnBuilderUuid struct {
 	fixedField           *common.FixedField
 	fieldnr              int
 	recordStructInstance *reflect.Value
 }
 
-func (c ColumnBuilderBytes) ParseValue(name string) bool {
-	c.recordStructInstance.Field(c.fieldnr).SetBytes([]byte(name))
+// Uuids are written as lower case 8-4-4-4-12 hex, 32 hex digits without dashes are accepted. Invalid values do not parse
+func (c *ColumnBuilderUuid) ParseValue(name string) bool {
+	field := c.recordStructInstance.Field(c.fieldnr)
+	uuid, canonical, ok := parseUuid(name)
+	if !ok {
+		field.SetString(name)
+		return false
+	}
+	if canonical {
+		field.SetString(name)
+	} else {
+		field.SetString(string(uuid[:]))
+	}
 	return true
 }
 
-func (c ColumnBuilderBytes) FinishColumn() bool {
+func (c *ColumnBuilderUuid) FinishColumn() bool {
 	return true
 }
 
+// parseUuid returns the uuid in canonical form, canonical is true when value already is, then uuid is not filled in
+func parseUuid(value string) (uuid [36]byte, canonical bool, ok bool) {
+	if 36 != len(value) && 32 != len(value) {
+		return uuid, false, false
+	}
+	canonical = 36 == len(value)
+	vi := 0
+	for ui := 0; ui < 36; ui++ {
+		if 8 == ui || 13 == ui || 18 == ui || 23 == ui {
+			uuid[ui] = '-'
+			if 36 == len(value) {
+				if '-' != value[vi] {
+					return uuid, false, false
+				}
+				vi++
+			}
+			continue
+		}
+		b := value[vi]
+		switch {
+		case '0' <= b && b <= '9', 'a' <= b && b <= 'f':
+		case 'A' <= b && b <= 'F':
+			b += 'a' - 'A'
+			canonical = false
+		default:
+			return uuid, false, false
+		}
+		uuid[ui] = b
+		vi++
+	}
+	return uuid, canonical, true
+}
+
 type ColumnBuilderDouble struct {
 	fixedField           *common.FixedField
 	fieldnr              int
@@ -91,6 +201,9 @@ type ColumnBuilderDouble struct {
 
 func (c ColumnBuilderDouble) ParseValue(name string) bool {
 	floatNum, err := strconv.ParseFloat(name, 64)
+	if c.fixedField.PointScale() > 0 && !strings.ContainsRune(name, '.') {
+		floatNum = floatNum / math.Pow10(c.fixedField.PointScale())
+	}
 	c.recordStructInstance.Field(c.fieldnr).SetFloat(floatNum)
 	return (nil == err)
 }
@@ -169,14 +282,15 @@ type ColumnBuilderDate struct {
 	recordStructInstance *reflect.Value
 }
 
+// Avro date, days since 1970-01-01
 func (c ColumnBuilderDate) ParseValue(name string) bool {
 
-	f, err := DateStringT1ToUnix_microsecond(name)
+	dt, err := c.fixedField.DateFormat.Parse(name)
 
-	if err == nil {
+	if nil != err {
 		return false
 	}
-	c.recordStructInstance.Field(c.fieldnr).SetInt(f)
+	c.recordStructInstance.Field(c.fieldnr).SetInt(dt.EpochDays())
 	return true
 }
 
@@ -192,12 +306,12 @@ type ColumnBuilderTimestapMillis struct {
 
 func (c ColumnBuilderTimestapMillis) ParseValue(name string) bool {
 
-	f, err := DateStringT1ToUnix_millisecond(name)
+	dt, err := c.fixedField.DateFormat.Parse(name)
 
-	if err == nil {
+	if nil != err {
 		return false
 	}
-	c.recordStructInstance.Field(c.fieldnr).SetInt(f)
+	c.recordStructInstance.Field(c.fieldnr).SetInt(DateTimeToTime(dt, c.fixedField.Location).UnixMilli())
 	return true
 }
 
@@ -213,15 +327,400 @@ type ColumnBuilderTimestapMicros struct {
 
 func (c ColumnBuilderTimestapMicros) ParseValue(name string) bool {
 
-	f, err := DateStringT1ToUnix_microsecond(name)
+	dt, err := c.fixedField.DateFormat.Parse(name)
 
-	if err != nil {
+	if nil != err {
 		return false
 	}
-	c.recordStructInstance.Field(c.fieldnr).SetInt(f)
+	c.recordStructInstance.Field(c.fieldnr).SetInt(DateTimeToTime(dt, c.fixedField.Location).UnixMicro())
 	return true
 }
 
 func (c ColumnBuilderTimestapMicros) FinishColumn() bool {
 	return true
 }
+
+// Avro time-millis, milliseconds since midnight
+type ColumnBuilderTimeMillis struct {
+	fixedField           *common.FixedField
+	fieldnr              int
+	recordStructInstance *reflect.Value
+}
+
+func (c ColumnBuilderTimeMillis) ParseValue(name string) bool {
+
+	dt, err := c.fixedField.DateFormat.Parse(name)
+
+	if nil != err {
+		return false
+	}
+	c.recordStructInstance.Field(c.fieldnr).SetInt(dt.NanosOfDay() / int64(time.Millisecond))
+	return true
+}
+
+func (c ColumnBuilderTimeMillis) FinishColumn() bool {
+	return true
+}
+
+// Avro time-micros, the field is a time.Duration since midnight that the codec writes as micros
+type ColumnBuilderTimeMicros struct {
+	fixedField           *common.FixedField
+	fieldnr              int
+	recordStructInstance *reflect.Value
+}
+
+func (c ColumnBuilderTimeMicros) ParseValue(name string) bool {
+
+	dt, err := c.fixedField.DateFormat.Parse(name)
+
+	if nil != err {
+		return false
+	}
+	c.recordStructInstance.Field(c.fieldnr).SetInt(dt.NanosOfDay())
+	return true
+}
+
+func (c ColumnBuilderTimeMicros) FinishColumn() bool {
+	return true
+}
+
+// Avro local-timestamp-millis, the wall clock time without time zone
+type ColumnBuilderLocalTimestampMillis struct {
+	fixedField           *common.FixedField
+	fieldnr              int
+	recordStructInstance *reflect.Value
+}
+
+func (c ColumnBuilderLocalTimestampMillis) ParseValue(name string) bool {
+
+	dt, err := c.fixedField.DateFormat.Parse(name)
+
+	if nil != err {
+		return false
+	}
+	c.recordStructInstance.Field(c.fieldnr).SetInt(dt.Local().UnixMilli())
+	return true
+}
+
+func (c ColumnBuilderLocalTimestampMillis) FinishColumn() bool {
+	return true
+}
+
+// Avro local-timestamp-micros, the wall clock time without time zone
+type ColumnBuilderLocalTimestampMicros struct {
+	fixedField           *common.FixedField
+	fieldnr              int
+	recordStructInstance *reflect.Value
+}
+
+func (c ColumnBuilderLocalTimestampMicros) ParseValue(name string) bool {
+
+	dt, err := c.fixedField.DateFormat.Parse(name)
+
+	if nil != err {
+		return false
+	}
+	c.recordStructInstance.Field(c.fieldnr).SetInt(dt.Local().UnixMicro())
+	return true
+}
+
+func (c ColumnBuilderLocalTimestampMicros) FinishColumn() bool {
+	return true
+}
+
+// Packed decimal (COMP-3), lands as the scaled value for decimal, double and float, as the unscaled number for int and long
+type ColumnBuilderPacked struct {
+	fixedField           *common.FixedField
+	fieldnr              int
+	recordStructInstance *reflect.Value
+}
+
+func (c ColumnBuilderPacked) ParseValue(name string) bool {
+	field := c.recordStructInstance.Field(c.fieldnr)
+	// Decimals take all 31 digits a packed field can hold, other types what fits in a long
+	if reflect.Ptr == field.Kind() {
+		err := UnpackBigDecimal(decimalField(field), name, c.fixedField.Precision, c.fixedField.Scale)
+		return (nil == err)
+	}
+	num, err := UnpackDecimal(name)
+	setScaledValue(field, num, c.fixedField.Scale)
+	return (nil == err)
+}
+
+func (c ColumnBuilderPacked) FinishColumn() bool {
+	return true
+}
+
+// Binary integer (COMP, COMP-4, COMP-5, BINARY), big endian and two's complement when signed. Scaled as packed decimal
+type ColumnBuilderBinary struct {
+	fixedField           *common.FixedField
+	fieldnr              int
+	recordStructInstance *reflect.Value
+}
+
+func (c ColumnBuilderBinary) ParseValue(name string) bool {
+	num, err := ParseBinary(name, "signed" == c.fixedField.Sign)
+	field := c.recordStructInstance.Field(c.fieldnr)
+	if nil == err && reflect.Ptr == field.Kind() {
+		err = checkPrecision(num, c.fixedField.Precision)
+	}
+	setScaledValue(field, num, c.fixedField.Scale)
+	return (nil == err)
+}
+
+func (c ColumnBuilderBinary) FinishColumn() bool {
+	return true
+}
+
+// Binary float (COMP-1 of 4 bytes, COMP-2 of 8 bytes) in IBM hexadecimal floating point or IEEE 754, big endian
+type ColumnBuilderBinaryFloat struct {
+	fixedField           *common.FixedField
+	fieldnr              int
+	recordStructInstance *reflect.Value
+}
+
+func (c ColumnBuilderBinaryFloat) ParseValue(name string) bool {
+	value, err := ParseBinaryFloat(name, "ieee" != c.fixedField.FloatFormat)
+	field := c.recordStructInstance.Field(c.fieldnr)
+	switch field.Kind() {
+	case reflect.Float32, reflect.Float64:
+		field.SetFloat(value)
+	case reflect.Ptr:
+		decimalField(field).SetFloat64(value)
+	default:
+		field.SetInt(int64(value))
+	}
+	return (nil == err)
+}
+
+func (c ColumnBuilderBinaryFloat) FinishColumn() bool {
+	return true
+}
+
+// Zoned decimal with overpunched or separate sign, lands as the scaled value for decimal, double and float, as the unscaled number for int and long
+type ColumnBuilderZoned struct {
+	fixedField           *common.FixedField
+	fieldnr              int
+	recordStructInstance *reflect.Value
+}
+
+func (c ColumnBuilderZoned) ParseValue(name string) bool {
+	num, err := ParseZoned(name, c.fixedField.Sign, c.fixedField.Overpunch)
+	field := c.recordStructInstance.Field(c.fieldnr)
+	if nil == err && reflect.Ptr == field.Kind() {
+		err = checkPrecision(num, c.fixedField.Precision)
+	}
+	setScaledValue(field, num, c.fixedField.Scale)
+	return (nil == err)
+}
+
+func (c ColumnBuilderZoned) FinishColumn() bool {
+	return true
+}
+
+// Avro decimal on bytes or fixed. 000001234567 is read with the implied scale, 12345.67 with the explicit decimal point
+type ColumnBuilderDecimal struct {
+	fixedField           *common.FixedField
+	fieldnr              int
+	recordStructInstance *reflect.Value
+}
+
+func (c ColumnBuilderDecimal) ParseValue(name string) bool {
+	rat := decimalField(c.recordStructInstance.Field(c.fieldnr))
+	err := ParseDecimal(rat, name, c.fixedField.Precision, c.fixedField.PointScale())
+	return (nil == err)
+}
+
+func (c ColumnBuilderDecimal) FinishColumn() bool {
+	return true
+}
+
+// setScaledValue stores an unscaled number in a decimal, double, float, int or long field
+func setScaledValue(field reflect.Value, num int64, scale int) {
+	switch field.Kind() {
+	case reflect.Float32, reflect.Float64:
+		field.SetFloat(float64(num) / math.Pow10(scale))
+	case reflect.Ptr:
+		SetScaled(decimalField(field), num, scale)
+	default:
+		field.SetInt(num)
+	}
+}
+
+// decimalField returns the *big.Rat of a decimal field, allocated once and reused for every row
+func decimalField(field reflect.Value) *big.Rat {
+	if field.IsNil() {
+		field.Set(reflect.ValueOf(new(big.Rat)))
+	}
+	return field.Interface().(*big.Rat)
+}
+
+// Nullable column, the field is a pointer that is nil for Avro null. The value builder parses into a
+// holder allocated once, the field then points at it.
+type ColumnBuilderNullable struct {
+	fixedField           *common.FixedField
+	fieldnr              int
+	recordStructInstance *reflect.Value
+	holder               reflect.Value
+	pointerValue         bool // The value type is a pointer itself, ie *big.Rat for decimal
+	builder              ColumnBuilder
+	nullSpaces           bool
+	space                byte // Second space byte, the EBCDIC space for binary fields
+	nullZeros            bool
+	nullValues           []string
+}
+
+func createNullableColumnBuilder(fieldnr int, fixedField *common.FixedField, columnsize int, recordStructInstance *reflect.Value) *ColumnBuilderNullable {
+	c := &ColumnBuilderNullable{fixedField: fixedField, fieldnr: fieldnr, recordStructInstance: recordStructInstance}
+
+	valueType := recordStructInstance.Type().Field(fieldnr).Type
+	c.pointerValue = valueType == reflect.TypeOf(&big.Rat{})
+	if !c.pointerValue {
+		valueType = valueType.Elem()
+	}
+	c.holder = reflect.New(reflect.StructOf([]reflect.StructField{{Name: "Value", Type: valueType}})).Elem()
+	c.builder = *createValueColumnBuilder(0, fixedField, columnsize, &c.holder)
+
+	for _, n := range fixedField.NullIf {
+		switch n {
+		case "spaces":
+			c.nullSpaces = true
+		case "zeros":
+			c.nullZeros = true
+		default:
+			c.nullValues = append(c.nullValues, n)
+		}
+	}
+	if 0 == len(fixedField.NullIf) {
+		c.nullSpaces = true
+	}
+	c.space = ' '
+	if fixedField.IsBinary() {
+		c.space = 0x40
+	}
+	return c
+}
+
+func (c *ColumnBuilderNullable) isNull(value string) bool {
+	if c.nullSpaces && allBytes(value, ' ', c.space) {
+		return true
+	}
+	if c.nullZeros && allBytes(value, '0', 0x00) {
+		return true
+	}
+	if 0 != len(c.nullValues) {
+		trimmed := strings.TrimSpace(value)
+		for _, n := range c.nullValues {
+			if n == trimmed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Null values land as null, values that do not parse reject the record like in a column that is not nullable
+func (c *ColumnBuilderNullable) ParseValue(name string) bool {
+	field := c.recordStructInstance.Field(c.fieldnr)
+
+	if c.isNull(name) {
+		field.Set(reflect.Zero(field.Type()))
+		return true
+	}
+	if !c.builder.ParseValue(name) {
+		field.Set(reflect.Zero(field.Type()))
+		return false
+	}
+	if c.pointerValue {
+		field.Set(c.holder.Field(0))
+	} else {
+		field.Set(c.holder.Field(0).Addr())
+	}
+	return true
+}
+
+func (c *ColumnBuilderNullable) FinishColumn() bool {
+	return true
+}
+
+// Element of an array of a primitive type, parsed into a holder allocated once and copied into the slice
+type ColumnBuilderElement struct {
+	fixedField *common.FixedField
+	holder     reflect.Value
+	builder    ColumnBuilder
+	element    reflect.Value
+}
+
+func createElementColumnBuilder(fixedField *common.FixedField, element reflect.Value) *ColumnBuilderElement {
+	c := &ColumnBuilderElement{fixedField: fixedField, element: element}
+	c.holder = reflect.New(reflect.StructOf([]reflect.StructField{{Name: "Value", Type: element.Type()}})).Elem()
+	c.builder = *CreateColumBuilder(0, fixedField, fixedField.Len, &c.holder)
+	return c
+}
+
+func (c *ColumnBuilderElement) ParseValue(name string) bool {
+	ok := c.builder.ParseValue(name)
+	c.element.Set(c.holder.Field(0))
+	return ok
+}
+
+func (c *ColumnBuilderElement) FinishColumn() bool {
+	return c.builder.FinishColumn()
+}
+
+// Filler and columns left out by the projection are consumed by position only
+type ColumnBuilderSkip struct {
+}
+
+func (c ColumnBuilderSkip) ParseValue(name string) bool {
+	return true
+}
+
+func (c ColumnBuilderSkip) FinishColumn() bool {
+	return true
+}
+
+// Removes the padding of a column before the builder parses it
+type ColumnBuilderTrim struct {
+	fixedField *common.FixedField
+	builder    ColumnBuilder
+	left       bool
+	right      bool
+	zero       bool // Numbers padded with 0 keep one 0 when all is padding
+}
+
+func createTrimColumnBuilder(fixedField *common.FixedField, builder ColumnBuilder) *ColumnBuilderTrim {
+	c := &ColumnBuilderTrim{fixedField: fixedField, builder: builder}
+	c.left = "left" == fixedField.Trim || "both" == fixedField.Trim
+	c.right = "right" == fixedField.Trim || "both" == fixedField.Trim
+	c.zero = "string" != fixedField.ColumnType && strings.ContainsRune(fixedField.Pad, '0')
+	return c
+}
+
+func (c *ColumnBuilderTrim) ParseValue(name string) bool {
+	value := name
+	if c.left {
+		value = strings.TrimLeft(value, c.fixedField.Pad)
+	}
+	if c.right {
+		value = strings.TrimRight(value, c.fixedField.Pad)
+	}
+	if c.zero && "" == value && strings.ContainsRune(name, '0') {
+		value = "0"
+	}
+	return c.builder.ParseValue(value)
+}
+
+func (c *ColumnBuilderTrim) FinishColumn() bool {
+	return c.builder.FinishColumn()
+}
+
+// allBytes is true when value only has the bytes a or b, the empty value included
+func allBytes(value string, a byte, b byte) bool {
+	for i := 0; i < len(value); i++ {
+		if value[i] != a && value[i] != b {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,217 @@
+/*
+ * MIT No Attribution
+ *
+ * Copyright 2021 Rickard Lundin (rickard@ignalina.dk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package fixed2avro
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hamba/avro"
+	"github.com/ignalina/shredder/common"
+)
+
+// roundTrip parses value with the builder of a one column layout, encodes the record and decodes it with hamba/avro
+func roundTrip(t *testing.T, column string, value string) interface{} {
+	t.Helper()
+	schemaAsString := `{"type":"record","name":"T","fields":[{"name":"V","type":` + column + `}]}`
+	row, err := common.CreateRowFromSchema(schemaAsString)
+	if nil != err {
+		t.Fatalf("layout %s: %v", column, err)
+	}
+	schema, err := common.CreateSchema(schemaAsString)
+	if nil != err {
+		t.Fatalf("schema %s: %v", column, err)
+	}
+
+	record := reflect.New(row.RecordStruct).Elem()
+	ff := &row.FixedField[0]
+	builder := *CreateColumBuilder(ff.FieldNr, ff, ff.Len, &record)
+	if !builder.ParseValue(value) {
+		t.Fatalf("%s does not parse %q", column, value)
+	}
+
+	data, err := avro.Marshal(*schema, record.Addr().Interface())
+	if nil != err {
+		t.Fatalf("encode %q: %v", value, err)
+	}
+	var decoded map[string]interface{}
+	err = avro.Unmarshal(*schema, data, &decoded)
+	if nil != err {
+		t.Fatalf("decode %q: %v", value, err)
+	}
+	return decoded["V"]
+}
+
+func TestDateTimeRoundTrip(t *testing.T) {
+	stockholm, err := time.LoadLocation("Europe/Stockholm")
+	if nil != err {
+		t.Skip("no time zone database")
+	}
+
+	tests := []struct {
+		name   string
+		column string
+		value  string
+		want   interface{}
+	}{
+		{"date", `{"type":"int","logicalType":"date","len":10}`, "2021-03-28",
+			time.Date(2021, 3, 28, 0, 0, 0, 0, time.UTC)},
+		{"date before epoch", `{"type":"int","logicalType":"date","len":8,"format":"yyyyMMdd"}`, "19691231",
+			time.Date(1969, 12, 31, 0, 0, 0, 0, time.UTC)},
+		{"date leap day", `{"type":"int","logicalType":"date","len":10,"format":"dd.MM.yyyy"}`, "29.02.2020",
+			time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"date day of year", `{"type":"int","logicalType":"date","len":6,"format":"CyyDDD"}`, "121060",
+			time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"date ignores time zone", `{"type":"int","logicalType":"date","len":10,"timeZone":"Pacific/Kiritimati"}`, "2021-01-01",
+			time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)},
+
+		{"time-millis", `{"type":"int","logicalType":"time-millis","len":12,"format":"HH:mm:ss.SSS"}`, "23:59:59.999",
+			23*time.Hour + 59*time.Minute + 59*time.Second + 999*time.Millisecond},
+		{"time-millis midnight", `{"type":"int","logicalType":"time-millis","len":8}`, "00.00.00",
+			time.Duration(0)},
+		{"time-millis truncates micros", `{"type":"int","logicalType":"time-millis","len":15,"format":"HH:mm:ss.SSSSSS"}`, "08:30:00.123999",
+			8*time.Hour + 30*time.Minute + 123*time.Millisecond},
+		{"time-micros", `{"type":"long","logicalType":"time-micros","len":15,"format":"HH:mm:ss.SSSSSS"}`, "12:00:00.000001",
+			12*time.Hour + time.Microsecond},
+		{"time-micros of DST change day", `{"type":"long","logicalType":"time-micros","len":8,"format":"HH:mm:ss","timeZone":"Europe/Stockholm"}`, "02:30:00",
+			2*time.Hour + 30*time.Minute},
+
+		{"local-timestamp-millis", `{"type":"long","logicalType":"local-timestamp-millis","len":23}`, "2021-07-01-12.00.00.250",
+			time.Date(2021, 7, 1, 12, 0, 0, 250000000, time.UTC).UnixMilli()},
+		{"local-timestamp-millis in DST gap", `{"type":"long","logicalType":"local-timestamp-millis","len":19,"format":"yyyy-MM-dd HH:mm:ss","timeZone":"Europe/Stockholm"}`, "2021-03-28 02:30:00",
+			time.Date(2021, 3, 28, 2, 30, 0, 0, time.UTC).UnixMilli()},
+		{"local-timestamp-micros", `{"type":"long","logicalType":"local-timestamp-micros","len":26}`, "1969-12-31-23.59.59.999999",
+			int64(-1)},
+
+		{"timestamp-millis winter", `{"type":"long","logicalType":"timestamp-millis","len":19,"format":"yyyy-MM-dd HH:mm:ss","timeZone":"Europe/Stockholm"}`, "2021-01-15 12:00:00",
+			time.Date(2021, 1, 15, 11, 0, 0, 0, time.UTC)},
+		{"timestamp-millis summer", `{"type":"long","logicalType":"timestamp-millis","len":19,"format":"yyyy-MM-dd HH:mm:ss","timeZone":"Europe/Stockholm"}`, "2021-07-01 12:00:00",
+			time.Date(2021, 7, 1, 10, 0, 0, 0, time.UTC)},
+		{"timestamp-millis after DST starts", `{"type":"long","logicalType":"timestamp-millis","len":19,"format":"yyyy-MM-dd HH:mm:ss","timeZone":"Europe/Stockholm"}`, "2021-03-28 03:00:00",
+			time.Date(2021, 3, 28, 1, 0, 0, 0, time.UTC)},
+		{"timestamp-micros after DST ends", `{"type":"long","logicalType":"timestamp-micros","len":26,"timeZone":"Europe/Stockholm"}`, "2021-10-31-03.00.00.000001",
+			time.Date(2021, 10, 31, 2, 0, 0, 1000, time.UTC)},
+		{"timestamp-micros without time zone is UTC", `{"type":"long","logicalType":"timestamp-micros","len":26}`, "2021-10-31-03.00.00.000001",
+			time.Date(2021, 10, 31, 3, 0, 0, 1000, time.UTC)},
+		{"timestamp-millis offset wins over time zone", `{"type":"long","logicalType":"timestamp-millis","len":25,"format":"iso8601","timeZone":"Europe/Stockholm"}`, "2021-07-01T12:00:00-05:00",
+			time.Date(2021, 7, 1, 17, 0, 0, 0, time.UTC)},
+		{"timestamp-millis iso without offset", `{"type":"long","logicalType":"timestamp-millis","len":23,"format":"iso8601","timeZone":"America/New_York"}`, "2021-12-01T08:00:00.500",
+			time.Date(2021, 12, 1, 13, 0, 0, 500000000, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := roundTrip(t, tt.column, tt.value)
+			if want, ok := tt.want.(time.Time); ok {
+				gotTime, ok := got.(time.Time)
+				if !ok || !gotTime.Equal(want) {
+					t.Errorf("%q decoded as %v, want %v", tt.value, got, want.In(stockholm))
+				}
+				return
+			}
+			if got != tt.want {
+				t.Errorf("%q decoded as %v (%T), want %v (%T)", tt.value, got, got, tt.want, tt.want)
+			}
+		})
+	}
+}
+
+func TestDateTimeInvalid(t *testing.T) {
+	for _, tt := range []struct {
+		column string
+		value  string
+	}{
+		{`{"type":"int","logicalType":"date","len":10}`, "2021-02-30"},
+		{`{"type":"int","logicalType":"date","len":10}`, "2021-13-01"},
+		{`{"type":"int","logicalType":"time-millis","len":8}`, "24.00.00"},
+		{`{"type":"long","logicalType":"timestamp-millis","len":23}`, "2021-07-01-12.61.00.000"},
+	} {
+		row, err := common.CreateRowFromSchema(`{"type":"record","name":"T","fields":[{"name":"V","type":` + tt.column + `}]}`)
+		if nil != err {
+			t.Fatal(err)
+		}
+		record := reflect.New(row.RecordStruct).Elem()
+		ff := &row.FixedField[0]
+		if (*CreateColumBuilder(ff.FieldNr, ff, ff.Len, &record)).ParseValue(tt.value) {
+			t.Errorf("%s parses invalid %q", tt.column, tt.value)
+		}
+	}
+}
+
+func TestZeroPad(t *testing.T) {
+	tests := []struct {
+		column string
+		value  string
+		want   interface{}
+	}{
+		{`{"type":"long","len":6,"pad":"0"}`, "001200", int64(1200)},
+		{`{"type":"long","len":6,"pad":"0"}`, "000000", int64(0)},
+		{`{"type":"int","len":6,"pad":"0 "}`, "  1200", 1200},
+		{`{"type":"double","len":6,"pad":"0","scale":2}`, "001200", float64(12)},
+		{`{"type":"long","len":6,"pad":"0","trim":"left"}`, "001200", int64(1200)},
+	}
+	for _, tt := range tests {
+		got := roundTrip(t, tt.column, tt.value)
+		if got != tt.want {
+			t.Errorf("%s %q decoded as %v (%T), want %v (%T)", tt.column, tt.value, got, got, tt.want, tt.want)
+		}
+	}
+
+	for _, column := range []string{
+		`{"type":"long","len":6,"pad":"0","trim":"both"}`,
+		`{"type":"bytes","logicalType":"decimal","precision":6,"scale":2,"len":6,"pad":"0","justify":"left"}`,
+	} {
+		_, err := common.CreateRowFromSchema(`{"type":"record","name":"T","fields":[{"name":"V","type":` + column + `}]}`)
+		if nil == err {
+			t.Errorf("%s trims the zeros on the right", column)
+		}
+	}
+}
+
+func TestNullable(t *testing.T) {
+	tests := []struct {
+		column string
+		value  string
+		parses bool
+		null   bool
+	}{
+		{`["null",{"type":"long","len":5}]`, "   12", true, false},
+		{`["null",{"type":"long","len":5}]`, "     ", true, true},
+		{`["null",{"type":"long","len":5}]`, "  1x2", false, true},
+		{`["null",{"type":"long","len":5,"nullIf":["zeros","-1"]}]`, "00000", true, true},
+		{`["null",{"type":"long","len":5,"nullIf":["zeros","-1"]}]`, "   -1", true, true},
+		{`["null",{"type":"long","len":5,"nullIf":["-1"]}]`, "     ", false, true},
+		{`["null",{"type":"boolean","len":1}]`, "X", false, true},
+		{`["null",{"type":"bytes","logicalType":"decimal","precision":3,"scale":1,"len":5}]`, "123.4", false, true},
+	}
+	for _, tt := range tests {
+		row, err := common.CreateRowFromSchema(`{"type":"record","name":"T","fields":[{"name":"V","type":` + tt.column + `}]}`)
+		if nil != err {
+			t.Fatal(err)
+		}
+		record := reflect.New(row.RecordStruct).Elem()
+		ff := &row.FixedField[0]
+		parses := (*CreateColumBuilder(ff.FieldNr, ff, ff.Len, &record)).ParseValue(tt.value)
+		if tt.parses != parses || tt.null != record.Field(0).IsNil() {
+			t.Errorf("%s %q parses %v null %v, want %v %v", tt.column, tt.value, parses, record.Field(0).IsNil(), tt.parses, tt.null)
+		}
+	}
+}
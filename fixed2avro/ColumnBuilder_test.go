@@ -0,0 +1,212 @@
+/*
+ * MIT No Attribution
+ *
+ * Copyright 2021 Rickard Lundin (rickard@ignalina.dk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package fixed2avro
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/hamba/avro/ocf"
+	"github.com/ignalina/shredder/common"
+)
+
+const testSchema = `{"type":"record","name":"T","fields":[
+ {"name":"Id","type":{"type":"long","name":"Id","len":4}},
+ {"name":"Name","type":{"type":"string","name":"Name","len":6}}]}`
+
+// shred writes the schema and data to files, shreds them to avro files with the options and decodes the rows of every chunk
+func shred(t *testing.T, schema string, data string, fast bool, options func(*common.FixedSizeTable)) ([]map[string]interface{}, *common.FixedSizeTable, error) {
+	t.Helper()
+	dir := t.TempDir()
+	schemaFile := filepath.Join(dir, "schema.json")
+	dataFile := filepath.Join(dir, "data.dat")
+	err := os.WriteFile(schemaFile, []byte(schema), 0644)
+	if nil == err {
+		err = os.WriteFile(dataFile, []byte(data), 0644)
+	}
+	if nil != err {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out") + string(os.PathSeparator)
+	err = os.Mkdir(out, 0755)
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	args := []string{"shredder", out, "", schemaFile, "1", "t", "1", dataFile}
+	fst := common.FixedSizeTable{
+		Args:           args,
+		SchemaFilePath: schemaFile,
+		Cores:          1,
+		SchemaID:       1,
+		Terminator:     "lf",
+		TrailerPattern: `^\*{12}`,
+		Compression:    "none",
+	}
+	if nil != options {
+		options(&fst)
+	}
+	table := Table{Fst: &fst}
+	if fast {
+		err = table.CreateFixedSizeTableFromFastDisk(dataFile, args)
+	} else {
+		err = table.CreateFixedSizeTableFromSlowDisk(dataFile, args)
+	}
+	if nil != err {
+		return nil, &fst, err
+	}
+
+	var rows []map[string]interface{}
+	for chunkNr := 0; chunkNr < fst.Cores; chunkNr++ {
+		f, err := os.Open(out + fst.Name + strconv.Itoa(chunkNr))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if nil != err {
+			t.Fatal(err)
+		}
+		decoder, err := ocf.NewDecoder(f)
+		if nil != err {
+			t.Fatal(err)
+		}
+		for decoder.HasNext() {
+			var row map[string]interface{}
+			err = decoder.Decode(&row)
+			if nil != err {
+				t.Fatal(err)
+			}
+			rows = append(rows, row)
+		}
+		f.Close()
+	}
+	return rows, &fst, nil
+}
+
+func TestLastRecordWithoutTerminator(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       string
+		terminator string
+		cores      int
+	}{
+		{"lf", "0001anna  \n0002bo    \n0003cleo  ", "lf", 1},
+		{"lf in chunks", "0001anna  \n0002bo    \n0003cleo  ", "lf", 3},
+		{"crlf", "0001anna  \r\n0002bo    \r\n0003cleo  ", "crlf", 2},
+	}
+	for _, tt := range tests {
+		for _, fast := range []bool{false, true} {
+			rows, _, err := shred(t, testSchema, tt.data, fast, func(fst *common.FixedSizeTable) {
+				fst.Terminator = tt.terminator
+				fst.Cores = tt.cores
+			})
+			if nil != err {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if 3 != len(rows) || "cleo" != rows[len(rows)-1]["Name"] {
+				t.Errorf("%s fast %v: rows %v, want the unterminated last record", tt.name, fast, rows)
+			}
+		}
+	}
+}
+
+func TestTrailer(t *testing.T) {
+	cp037, err := common.GetEncoding("cp037")
+	if nil != err {
+		t.Fatal(err)
+	}
+	ebcdic := func(s string) string {
+		b, err := common.EncodeTerminator(cp037, []byte(s))
+		if nil != err {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	tests := []struct {
+		name    string
+		data    string
+		options func(*common.FixedSizeTable)
+	}{
+		{"trailer lines without final terminator", "HDR\n0001anna  \n0002bo    \n0003cleo  \nTRL0000003", func(fst *common.FixedSizeTable) {
+			fst.HeaderLines = 1
+			fst.TrailerLines = 1
+			fst.TrailerCount = "3:7"
+		}},
+		{"trailer pattern", "0001anna  \n0002bo    \n0003cleo  \n************ 3\n", func(fst *common.FixedSizeTable) {
+			fst.Cores = 2
+		}},
+		{"trailer pattern in the input encoding", ebcdic("0001anna  0002bo    0003cleo  ***TRL****"), func(fst *common.FixedSizeTable) {
+			fst.Encoding = "cp037"
+			fst.Terminator = "none"
+			fst.TrailerPattern = `^\*{3}TRL`
+		}},
+	}
+	for _, tt := range tests {
+		for _, fast := range []bool{false, true} {
+			rows, fst, err := shred(t, testSchema, tt.data, fast, tt.options)
+			if nil != err {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if 3 != len(rows) || 3 != fst.LinesParsed {
+				t.Errorf("%s fast %v: %d rows %d parsed lines, want 3", tt.name, fast, len(rows), fst.LinesParsed)
+			}
+		}
+	}
+}
+
+func TestProjectedOutColumns(t *testing.T) {
+	data := "0001anna  \n0002bo    \n0003cleo  \nTRL0000006\n"
+	tests := []struct {
+		name    string
+		options func(*common.FixedSizeTable)
+		rows    int
+	}{
+		{"where", func(fst *common.FixedSizeTable) {
+			fst.Where = "Id >= 2"
+		}, 2},
+		{"trailer sum", func(fst *common.FixedSizeTable) {
+			fst.TrailerSum = "3:7:Id"
+		}, 3},
+		{"where and trailer sum", func(fst *common.FixedSizeTable) {
+			fst.Where = "id != 2"
+			fst.TrailerSum = "3:7:id"
+		}, 2},
+	}
+	for _, tt := range tests {
+		rows, _, err := shred(t, testSchema, data, false, func(fst *common.FixedSizeTable) {
+			fst.Columns = "Name"
+			fst.TrailerLines = 1
+			tt.options(fst)
+		})
+		if nil != err {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if tt.rows != len(rows) {
+			t.Errorf("%s: %d rows, want %d", tt.name, len(rows), tt.rows)
+		}
+		for _, row := range rows {
+			if _, ok := row["Id"]; ok || 1 != len(row) {
+				t.Errorf("%s: row %v, want only Name", tt.name, row)
+			}
+		}
+	}
+}
@@ -0,0 +1,95 @@
+/*
+ * MIT No Attribution
+ *
+ * Copyright 2021 Rickard Lundin (rickard@ignalina.dk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package fixed2avro
+
+import (
+	"github.com/ignalina/shredder/common"
+	"math/big"
+	"reflect"
+)
+
+// derivedColumn sets a derived column from its expression, nullable columns point to a holder allocated once
+type derivedColumn struct {
+	derived *common.DerivedField
+	field   reflect.Value
+	holder  reflect.Value
+	decimal bool
+}
+
+func createDerivedColumn(derived *common.DerivedField, record reflect.Value) derivedColumn {
+	d := derivedColumn{derived: derived, field: fieldValue(record, derived.Path)}
+	d.decimal = d.field.Type() == reflect.TypeOf(&big.Rat{})
+	if reflect.Ptr == d.field.Kind() && !d.decimal {
+		d.holder = reflect.New(d.field.Type().Elem())
+	}
+	return d
+}
+
+// evaluate computes the column of the current record. Null, and values that do not convert to the column type,
+// are null for nullable columns and zero otherwise.
+func (d *derivedColumn) evaluate(record reflect.Value) bool {
+	value := d.derived.Compiled.Eval(record)
+
+	if d.decimal {
+		r, ok := value.BigRat()
+		if !ok || nil == r {
+			if d.derived.Nullable {
+				d.field.Set(reflect.Zero(d.field.Type()))
+			} else {
+				d.field.Set(reflect.ValueOf(new(big.Rat)))
+			}
+			return false
+		}
+		d.field.Set(reflect.ValueOf(r))
+		return true
+	}
+
+	target := d.field
+	if d.holder.IsValid() {
+		target = d.holder.Elem()
+	}
+	ok := common.ExprNull != value.Kind
+	if ok {
+		switch target.Kind() {
+		case reflect.String:
+			target.SetString(value.String())
+		case reflect.Int, reflect.Int32, reflect.Int64:
+			var i int64
+			i, ok = value.Int64()
+			target.SetInt(i)
+		case reflect.Float32, reflect.Float64:
+			var f float64
+			f, ok = value.Float64()
+			target.SetFloat(f)
+		case reflect.Bool:
+			var b bool
+			b, ok = value.Boolean()
+			target.SetBool(b)
+		}
+	}
+	if !ok {
+		d.field.Set(reflect.Zero(d.field.Type()))
+		return false
+	}
+	if d.holder.IsValid() {
+		d.field.Set(d.holder)
+	}
+	return true
+}
@@ -43,6 +43,7 @@ type KafkaExporter struct {
 	Topic            string
 	producer         *kafkaavro.Producer
 	C                chan kafka.Event
+	produced         int
 }
 
 func (ep *KafkaExporter) Setup() error {
@@ -86,11 +87,17 @@ func (ep *KafkaExporter) ExportRow() error {
 	binaryMsg = append(binaryMsg, binaryValue...)
 
 	ep.producer.ProduceFast("string", binaryMsg, ep.C)
+	ep.produced++
 
 	return nil
 }
 
 func (ep *KafkaExporter) Finish() error {
+	// Nothing to wait for, ie a record type missing in this chunk
+	if 0 == ep.produced {
+		return nil
+	}
+
 	// Wait for a successfull kafka transmission
 
 	e := <-ep.C
@@ -107,11 +114,12 @@ type AvroFileExporter struct {
 	FileName string
 	file     *os.File
 	enc      *ocf.Encoder
+	rows     int
 }
 
 func (ep *AvroFileExporter) Setup() error {
 
-	f, err := os.OpenFile(ep.FileName+strconv.Itoa(ep.Fstc.Chunkr), os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
+	f, err := os.OpenFile(ep.FileName+ep.Fstc.FixedSizeTable.Name+strconv.Itoa(ep.Fstc.Chunkr), os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		return err
 	}
@@ -124,9 +132,8 @@ func (ep *AvroFileExporter) Setup() error {
 
 func (ep *AvroFileExporter) ExportRow() error {
 
-	ep.enc.Encode(ep.Fstc.RecordStructInstance.Addr().Interface())
-
-	return nil
+	ep.rows++
+	return ep.enc.Encode(ep.Fstc.RecordStructInstance.Addr().Interface())
 }
 
 func (ep *AvroFileExporter) Finish() error {
@@ -134,6 +141,10 @@ func (ep *AvroFileExporter) Finish() error {
 	ep.enc.Close()
 	ep.file.Close()
 
+	// The ocf header is only written together with the first block, an empty file is not valid avro
+	if 0 == ep.rows {
+		return os.Remove(ep.file.Name())
+	}
 	return nil
 }
 
@@ -151,9 +162,14 @@ func ExportersFactory(args []string, chunk *common.FixedSizeTableChunk) *ExportP
 
 		ip = strings.TrimPrefix(url, proto)
 
+		topic := args[5]
+		if "" != chunk.FixedSizeTable.Topic {
+			topic = chunk.FixedSizeTable.Topic
+		}
+
 		ptrExportProducer = &KafkaExporter{
 			BootstrapServers: ip,
-			Topic:            os.Args[5],
+			Topic:            topic,
 			Fstc:             chunk,
 		}
 	} else if !httpType {
@@ -0,0 +1,71 @@
+/*
+ * MIT No Attribution
+ *
+ * Copyright 2021 Rickard Lundin (rickard@ignalina.dk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package fixed2avro
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// MapChunks maps the file and cuts the mapping in a chunk per core, the chunks are parsed from the mapped pages.
+// The file is not copied, each record is copied to a string when it is parsed.
+// A compressed file is decompressed from the mapping, an empty file is read as from slow disk.
+func MapChunks(t *Table, filename string, args []string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	fi, err := file.Stat()
+	if nil != err {
+		return err
+	}
+	if 0 == fi.Size() {
+		return ParalizeChunks(t, filename, args)
+	}
+
+	start := time.Now()
+	mapped, err := mapFile(file, fi.Size())
+	if nil != err {
+		return fmt.Errorf("map %s: %v", filename, err)
+	}
+	// Nothing refers to the mapping once the chunks are exported, the parsed values are copies
+	defer unmapFile(mapped)
+
+	head := mapped
+	if len(head) > 10 {
+		head = head[:10]
+	}
+	compression, err := fileCompression(t.Fst, filename, head)
+	if nil != err {
+		return err
+	}
+	if "" != compression {
+		t.Fst.Bytes, err = decompressFile(t.Fst, compression, mapped, filename, start)
+		if nil != err {
+			return err
+		}
+	} else {
+		t.Fst.Bytes = mapped
+	}
+
+	return t.processChunks(nil, args)
+}
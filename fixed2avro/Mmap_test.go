@@ -0,0 +1,111 @@
+/*
+ * MIT No Attribution
+ *
+ * Copyright 2021 Rickard Lundin (rickard@ignalina.dk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package fixed2avro
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ignalina/shredder/common"
+)
+
+const benchSchema = `{"type":"record","name":"Bench","fields":[
+ {"name":"Id","type":{"type":"long","name":"Id","len":9}},
+ {"name":"Name","type":{"type":"string","name":"Name","len":20}},
+ {"name":"Booked","type":{"type":"int","logicalType":"date","name":"Booked","len":10}},
+ {"name":"Amount","type":{"type":"double","name":"Amount","len":12,"scale":2}}]}`
+
+// benchFile writes the schema and a fixed width file of rows records, lf terminated
+func benchFile(b *testing.B, rows int) (string, string, int64) {
+	b.Helper()
+	dir := b.TempDir()
+	schema := filepath.Join(dir, "bench.json")
+	err := os.WriteFile(schema, []byte(benchSchema), 0644)
+	if nil != err {
+		b.Fatal(err)
+	}
+
+	data := filepath.Join(dir, "bench.dat")
+	f, err := os.Create(data)
+	if nil != err {
+		b.Fatal(err)
+	}
+	w := bufio.NewWriter(f)
+	for i := 0; i < rows; i++ {
+		fmt.Fprintf(w, "%09d%-20s2021-%02d-%02d%012d\n", i, fmt.Sprintf("customer %d", i%1000), 1+i%12, 1+i%28, i*7)
+	}
+	err = w.Flush()
+	if nil == err {
+		err = f.Close()
+	}
+	if nil != err {
+		b.Fatal(err)
+	}
+	fi, err := os.Stat(data)
+	if nil != err {
+		b.Fatal(err)
+	}
+	return schema, data, fi.Size()
+}
+
+// benchmarkDisk shreds the file to avro files in 4 chunks, read into memory from slow disk or mapped from fast disk
+func benchmarkDisk(b *testing.B, fast bool) {
+	schema, data, size := benchFile(b, 1000000)
+	out := b.TempDir() + string(os.PathSeparator)
+	args := []string{"shredder", out, "", schema, "1", "bench", "4", data}
+
+	b.SetBytes(size)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fst := common.FixedSizeTable{
+			Args:           args,
+			SchemaFilePath: schema,
+			Cores:          4,
+			SchemaID:       1,
+			Terminator:     "lf",
+			TrailerPattern: `^\*{12}`,
+			Compression:    "none",
+		}
+		t := Table{Fst: &fst}
+		var err error
+		if fast {
+			err = t.CreateFixedSizeTableFromFastDisk(data, args)
+		} else {
+			err = t.CreateFixedSizeTableFromSlowDisk(data, args)
+		}
+		if nil != err {
+			b.Fatal(err)
+		}
+		if 1000000 != fst.LinesParsed {
+			b.Fatalf("parsed %d lines", fst.LinesParsed)
+		}
+	}
+}
+
+func BenchmarkParalizeChunks(b *testing.B) {
+	benchmarkDisk(b, false)
+}
+
+func BenchmarkMapChunks(b *testing.B) {
+	benchmarkDisk(b, true)
+}
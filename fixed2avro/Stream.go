@@ -0,0 +1,243 @@
+/*
+ * MIT No Attribution
+ *
+ * Copyright 2021 Rickard Lundin (rickard@ignalina.dk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package fixed2avro
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/ignalina/shredder/common"
+	"github.com/inhies/go-bytesize"
+	"io"
+	"os"
+	"time"
+)
+
+// Streaming reads the input in blocks from any reader, ie stdin or a named pipe, instead of the whole file at once.
+// Each block ends on a record boundary, the incomplete record after it starts the next block. The blocks are parsed by
+// a pool of cores workers, each with its own exporter, and a block buffer is reused once its worker is done with it.
+// The memory ceiling divided by the block size gives the number of block buffers, reading waits while all are in use.
+
+// streamBlock is a block of whole records read into a buffer of the pool
+type streamBlock struct {
+	buffer []byte
+	data   []byte
+	first  bool
+	last   bool
+}
+
+// streamInput tells if the input is streamed, with -stream, for stdin "-" and for named pipes
+func streamInput(fst *common.FixedSizeTable, fileName string) bool {
+	if fst.Stream || "-" == fileName {
+		return true
+	}
+	fi, err := os.Stat(fileName)
+	return nil == err && !fi.Mode().IsRegular()
+}
+
+// streamBuffers returns the block size and how many block buffers fit within the memory ceiling
+func streamBuffers(fst *common.FixedSizeTable) (int, int, error) {
+	blockSize, err := bytesize.Parse(fst.BlockSize)
+	if nil != err {
+		return 0, 0, fmt.Errorf("block size %s: %v", fst.BlockSize, err)
+	}
+	memory, err := bytesize.Parse(fst.MemoryLimit)
+	if nil != err {
+		return 0, 0, fmt.Errorf("memory %s: %v", fst.MemoryLimit, err)
+	}
+	// One block is read while another is parsed
+	buffers := int(memory / blockSize)
+	if buffers < 2 {
+		return 0, 0, fmt.Errorf("memory %s does not hold two blocks of %s", fst.MemoryLimit, fst.BlockSize)
+	}
+	return int(blockSize), buffers, nil
+}
+
+// StreamChunks reads the input block by block and hands the blocks to the workers, only the blocks in flight are in memory
+func StreamChunks(t *Table, fileName string, args []string) error {
+	blockSize, buffers, err := streamBuffers(t.Fst)
+	if nil != err {
+		return err
+	}
+
+	var input io.Reader = os.Stdin
+	if "-" != fileName {
+		file, err := os.Open(fileName)
+		if nil != err {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+	decompressed, err := streamDecompressor(t.Fst, bufio.NewReader(input), fileName)
+	if nil != err {
+		return err
+	}
+	defer decompressed.Close()
+	input = decompressed
+
+	// Quote and escape as they are in the input, blocks are cut before decoding
+	var quote, escape byte
+	if nil != t.Fst.Delimited {
+		quote, escape, err = encodedQuotes(t.Fst)
+		if nil != err {
+			return err
+		}
+	}
+
+	t.Fst.TableChunks = make([]common.FixedSizeTableChunk, t.Fst.Cores)
+	t.TableChunks = make([]TableChunk, t.Fst.Cores)
+	blocks := make(chan streamBlock)
+	pool := &blockPool{free: make(chan []byte, buffers), size: blockSize, left: buffers}
+	for w := range t.TableChunks {
+		t.createChunk(w, args)
+		go t.TableChunks[w].streamWorker(blocks, pool)
+	}
+
+	err = t.readBlocks(bufio.NewReader(input), blocks, pool, quote, escape)
+	close(blocks)
+	t.Fst.Wg.Wait()
+	if nil != err {
+		return err
+	}
+
+	return t.finishChunks()
+}
+
+// readBlocks fills the buffers from the input and sends them to the workers cut after the last whole record
+func (t *Table) readBlocks(input *bufio.Reader, blocks chan<- streamBlock, pool *blockPool, quote byte, escape byte) error {
+	buffer := pool.get()
+	carry := 0
+	first := true
+
+	for {
+		startReadChunk := time.Now()
+		n, err := io.ReadFull(input, buffer[carry:])
+		if nil != err && io.EOF != err && io.ErrUnexpectedEOF != err {
+			return err
+		}
+		// Peeking tells if this is the last block, it holds the trailer lines
+		_, err = input.Peek(1)
+		t.Fst.DurationReadChunk += time.Since(startReadChunk)
+		last := nil != err
+		if last && io.EOF != err {
+			return err
+		}
+		t.Fst.StreamedBytes += n
+
+		end := carry + n
+		if first && nil != t.Fst.Delimited && t.Fst.Delimited.Header {
+			err = readHeaderRow(t.Fst, buffer[:end])
+			if nil != err {
+				return err
+			}
+		}
+		cut := end
+		if !last {
+			cut = recordsEnd(t.Fst, buffer[:end], quote, escape)
+			if 0 == cut {
+				return fmt.Errorf("no whole record in a block of %d bytes, use a larger -block-size", len(buffer))
+			}
+			// The last records wait for the next block until it is known they are not the trailer lines
+			for i := 0; i < t.Fst.TrailerLines && cut > 0; i++ {
+				cut = recordsEnd(t.Fst, buffer[:cut-1], quote, escape)
+			}
+			if 0 == cut {
+				return fmt.Errorf("no record before the %d trailer lines in a block of %d bytes, use a larger -block-size", t.Fst.TrailerLines, len(buffer))
+			}
+		}
+
+		var next []byte
+		if !last {
+			next = pool.get()
+			carry = copy(next, buffer[cut:end])
+		}
+		t.Fst.Wg.Add(1)
+		blocks <- streamBlock{buffer: buffer, data: buffer[:cut], first: first, last: last}
+		if last {
+			return nil
+		}
+		buffer = next
+		first = false
+	}
+}
+
+// recordsEnd returns the end of the last whole record in a block that starts with a record
+func recordsEnd(fst *common.FixedSizeTable, block []byte, quote byte, escape byte) int {
+	if "" != fst.Descriptor {
+		return common.FindLastDescriptor(block, 0, len(block))
+	} else if nil != fst.Delimited {
+		return common.FindLastDelimitedRecord(block, 0, len(block), fst.Newline, quote, escape)
+	} else if !fst.RawRecords() {
+		if p := common.FindLastNL(block, fst.Newline); p > 0 {
+			return p
+		}
+		return 0
+	}
+	recordLength := fst.DataLength() + len(fst.Newline)
+	return len(block) / recordLength * recordLength
+}
+
+// streamWorker parses the blocks it gets into the exporter of its chunk and gives the buffers back to the pool
+func (tb *TableChunk) streamWorker(blocks <-chan streamBlock, pool *blockPool) {
+	for block := range blocks {
+		tb.fstc.Bytes = block.data
+		tb.fstc.First = block.first
+		tb.fstc.Last = block.last
+		tb.process()
+		tb.fstc.Bytes = nil
+		pool.put(block.buffer)
+	}
+}
+
+// streamDecompressor decompresses the stream when it is compressed, the compression is found as for files.
+// Closing it releases the decoder, not the input.
+func streamDecompressor(fst *common.FixedSizeTable, input *bufio.Reader, fileName string) (io.ReadCloser, error) {
+	head, _ := input.Peek(10)
+	compression, err := fileCompression(fst, fileName, head)
+	if nil != err || "" == compression {
+		return io.NopCloser(input), err
+	}
+	fst.Compression = compression
+	return common.DecompressReader(compression, input, fst.Cores)
+}
+
+// blockPool hands out at most left more new buffers, after that a buffer given back by a worker
+type blockPool struct {
+	free chan []byte
+	size int
+	left int
+}
+
+func (p *blockPool) get() []byte {
+	select {
+	case buffer := <-p.free:
+		return buffer
+	default:
+	}
+	if p.left > 0 {
+		p.left--
+		return make([]byte, p.size)
+	}
+	return <-p.free
+}
+
+func (p *blockPool) put(buffer []byte) {
+	p.free <- buffer
+}
@@ -0,0 +1,196 @@
+/*
+ * MIT No Attribution
+ *
+ * Copyright 2021 Rickard Lundin (rickard@ignalina.dk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package fixed2avro
+
+import (
+	"fmt"
+	"github.com/ignalina/shredder/common"
+	"math/big"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+func setupHeaderTrailer(fst *common.FixedSizeTable) error {
+	var err error
+
+	if "" != fst.HeaderPattern {
+		fst.HeaderRegexp, err = regexp.Compile(fst.HeaderPattern)
+		if nil != err {
+			return err
+		}
+	}
+	if "" != fst.TrailerPattern {
+		fst.TrailerRegexp, err = regexp.Compile(fst.TrailerPattern)
+		if nil != err {
+			return err
+		}
+		// An anchored pattern only matches records starting with its literal prefix, the others are not decoded and matched
+		if strings.HasPrefix(fst.TrailerPattern, "^") {
+			fst.TrailerPrefix, _ = fst.TrailerRegexp.LiteralPrefix()
+			raw, err := common.EncodeTerminator(fst.InputEncoding, []byte(fst.TrailerPrefix))
+			if nil != err {
+				return fmt.Errorf("trailer pattern %s: %v", fst.TrailerPattern, err)
+			}
+			fst.TrailerRawPrefix = string(raw)
+		}
+	}
+	fst.TrailerCountField, err = common.ParseTrailerField(fst.TrailerCount, false)
+	if nil != err {
+		return err
+	}
+	fst.TrailerSumField, err = common.ParseTrailerField(fst.TrailerSum, true)
+	if nil != err {
+		return err
+	}
+
+	if nil != fst.TrailerSumField {
+		found := false
+		for _, rt := range append([]*common.FixedSizeTable{fst}, fst.RecordTypes...) {
+			if nil != rt.Row && columnIndex(rt, fst.TrailerSumField.Column) >= 0 {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("hash total column %s not found", fst.TrailerSumField.Column)
+		}
+	}
+	return nil
+}
+
+// columnIndex returns the top level column by name, -1 if the table does not have it or skips it
+func columnIndex(fst *common.FixedSizeTable, column string) int {
+	for i, ff := range fst.Row.FixedField {
+		if ff.TopLevel() && strings.EqualFold(ff.Name, column) {
+			return i
+		}
+	}
+	return -1
+}
+
+// addToHashTotal adds the parsed value of the hash total column
+func (tb *TableChunk) addToHashTotal() {
+	field := tb.fstc.RecordStructInstance.Field(tb.fstc.FixedSizeTable.Row.FixedField[tb.sumIndex].FieldNr)
+
+	// Nullable columns are pointers, null adds nothing
+	if reflect.Ptr == field.Kind() && field.Type() != reflect.TypeOf(&big.Rat{}) {
+		if field.IsNil() {
+			return
+		}
+		field = field.Elem()
+	}
+
+	switch field.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		tb.hashValue.SetInt64(field.Int())
+	case reflect.Float32, reflect.Float64:
+		// The shortest decimal that reads back as the float, the exact binary value never adds up to a decimal total
+		bitSize := 64
+		if reflect.Float32 == field.Kind() {
+			bitSize = 32
+		}
+		tb.hashValue.SetString(strconv.FormatFloat(field.Float(), 'g', -1, bitSize))
+	case reflect.Ptr:
+		if field.IsNil() {
+			return
+		}
+		tb.hashValue.Set(decimalField(field))
+	default:
+		return
+	}
+	tb.fstc.HashTotal.Add(&tb.hashValue, &tb.fstc.HashTotal)
+}
+
+// reconcileTrailer checks the record count and hash total of the trailer against what was parsed
+func reconcileTrailer(fst *common.FixedSizeTable) error {
+	if nil == fst.TrailerCountField && nil == fst.TrailerSumField {
+		return nil
+	}
+
+	trailer := ""
+	for _, tableChunk := range fst.TableChunks {
+		if "" != tableChunk.Trailer {
+			trailer = tableChunk.Trailer
+			break
+		}
+	}
+	if "" == trailer {
+		return fmt.Errorf("no trailer record found to reconcile with")
+	}
+
+	if nil != fst.TrailerCountField {
+		value, err := fst.TrailerCountField.Value(trailer)
+		if nil != err {
+			return err
+		}
+		count, err := strconv.Atoi(strings.TrimSpace(value))
+		if nil != err {
+			return fmt.Errorf("trailer record count %s is not a number", value)
+		}
+		if count != fst.LinesParsed {
+			return fmt.Errorf("trailer record count %d does not match %d parsed lines", count, fst.LinesParsed)
+		}
+	}
+
+	if nil != fst.TrailerSumField {
+		var sum big.Rat
+		var field *common.FixedField
+		for _, rt := range append([]*common.FixedSizeTable{fst}, fst.RecordTypes...) {
+			if nil == rt.Row {
+				continue
+			}
+			if i := columnIndex(rt, fst.TrailerSumField.Column); i >= 0 {
+				field = &rt.Row.FixedField[i]
+			}
+			for i := range rt.TableChunks {
+				sum.Add(&sum, &rt.TableChunks[i].HashTotal)
+			}
+		}
+
+		value, err := fst.TrailerSumField.Value(trailer)
+		if nil != err {
+			return err
+		}
+		var expected big.Rat
+		if "" == field.Usage && "" != field.Sign {
+			num, err := ParseZoned(value, field.Sign, field.Overpunch)
+			if nil != err {
+				return fmt.Errorf("trailer hash total %s: %s", value, err.Error())
+			}
+			SetScaled(&expected, num, field.Scale)
+		} else if err = ParseDecimal(&expected, value, 0, field.Scale); nil != err {
+			return fmt.Errorf("trailer hash total %s: %s", value, err.Error())
+		}
+		if 0 != expected.Cmp(&sum) {
+			return fmt.Errorf("trailer hash total %s does not match the sum %s of column %s", hashString(&expected, field.Scale), hashString(&sum, field.Scale), fst.TrailerSumField.Column)
+		}
+	}
+	return nil
+}
+
+// hashString writes a hash total with the scale of the column, or with all its decimals when it has more
+func hashString(r *big.Rat, scale int) string {
+	scaled := new(big.Rat).Mul(r, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)))
+	if scaled.IsInt() {
+		return r.FloatString(scale)
+	}
+	return common.RatString(r)
+}
@@ -20,23 +20,33 @@
 package fixed2avro
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
 	"github.com/ignalina/shredder/common"
 	"github.com/inhies/go-bytesize"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 	"unicode/utf8"
 )
 
 type Substring struct {
-	runeLen int
-	sub     string
+	width int // Characters, or bytes for byte widths and raw records
+	sub   string
 }
 
 func createSubstring(fst *common.FixedSizeTable) []Substring {
 
 	substring := make([]Substring, len(fst.Row.FixedField))
 	for ci, cc := range fst.Row.FixedField {
-		substring[ci].runeLen = cc.Len
+		substring[ci].width = cc.Len
 	}
 
 	return substring
@@ -50,30 +60,562 @@ func getSplitBytePositions(fullString string, substring []Substring) {
 	for is, s := range substring {
 
 		var runeLen int
+		// A short record gives the rest, and empty columns after it. Width 0 is an absent OCCURS element
+		pos := lastByte
+		if 0 == s.width {
+			pos = firstByte
+		}
 
-		for bytePos, runan := range fullString[firstByte:lastByte] {
+		for bytePos, runan := range fullString[firstByte:pos] {
 			runeLen++
-			if runeLen == s.runeLen {
-				pos := firstByte + bytePos + utf8.RuneLen(runan)
-				substring[is].sub = fullString[firstByte:pos]
-				firstByte = pos
+			if runeLen == s.width {
+				pos = firstByte + bytePos + utf8.RuneLen(runan)
 				break
 			}
 		}
+		substring[is].sub = fullString[firstByte:pos]
+		firstByte = pos
+	}
+}
+
+// getSplitFixedPositions slices a record where the widths are in bytes, no rune counting needed
+func getSplitFixedPositions(record string, substring []Substring) {
+	var firstByte int
+
+	for is, s := range substring {
+		lastByte := firstByte + s.width
+		if lastByte > len(record) {
+			lastByte = len(record)
+		}
+		substring[is].sub = record[firstByte:lastByte]
+		firstByte = lastByte
+	}
+}
+
+// scanFixedRecords is a bufio.SplitFunc for records of exact byte length followed by a separator,
+// a binary field may contain newline bytes so ScanLines can not be used.
+func scanFixedRecords(recordLength int, separatorLength int) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if atEOF && 0 == len(data) {
+			return 0, nil, nil
+		}
+		if len(data) >= recordLength+separatorLength {
+			return recordLength + separatorLength, data[:recordLength], nil
+		}
+		if atEOF {
+			if len(data) > recordLength {
+				return len(data), data[:recordLength], nil
+			}
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	}
+}
+
+// scanTerminatedRecords is a bufio.SplitFunc for records ending with the configured terminator,
+// unlike ScanLines a lone \n is kept in the record when the terminator is \r\n.
+func scanTerminatedRecords(terminator []byte) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if atEOF && 0 == len(data) {
+			return 0, nil, nil
+		}
+		if i := bytes.Index(data, terminator); i >= 0 {
+			return i + len(terminator), data[:i], nil
+		}
+		if atEOF {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	}
+}
+
+// scanDelimitedRecords is a bufio.SplitFunc for delimited records, a terminator in a quoted field does not end the record
+func scanDelimitedRecords(terminator []byte, quote byte, escape byte) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if atEOF && 0 == len(data) {
+			return 0, nil, nil
+		}
+		if i := common.RecordEnd(data, terminator, quote, escape); i >= 0 {
+			return i, data[:i-len(terminator)], nil
+		}
+		if atEOF {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	}
+}
+
+// recordScanner splits a chunk that is all in memory with a bufio.SplitFunc. Unlike bufio.Scanner the chunk is not
+// copied into a buffer first and there is no limit on the record length, Text copies one record at a time.
+type recordScanner struct {
+	data  []byte
+	split bufio.SplitFunc
+	token []byte
+	err   error
+}
+
+func newRecordScanner(data []byte, split bufio.SplitFunc) *recordScanner {
+	return &recordScanner{data: data, split: split}
+}
+
+// Scan moves to the next record, false at the end of the chunk or on an error
+func (s *recordScanner) Scan() bool {
+	for 0 != len(s.data) && nil == s.err {
+		advance, token, err := s.split(s.data, true)
+		if bufio.ErrFinalToken == err {
+			s.data = nil
+			s.token = token
+			return nil != token
+		}
+		if nil != err {
+			s.err = err
+			return false
+		}
+		if advance <= 0 || advance > len(s.data) {
+			return false
+		}
+		s.data = s.data[advance:]
+		if nil != token {
+			s.token = token
+			return true
+		}
+	}
+	return false
+}
+
+// Text is the record as a string, a copy the column values can keep after the chunk is gone
+func (s *recordScanner) Text() string {
+	return string(s.token)
+}
+
+func (s *recordScanner) Err() error {
+	return s.err
+}
+
+// readCompressed returns the decompressed file, nil when it is not compressed
+func readCompressed(fst *common.FixedSizeTable, file *os.File, fileName string) ([]byte, error) {
+	head := make([]byte, 10)
+	n, _ := io.ReadFull(file, head)
+	_, err := file.Seek(0, io.SeekStart)
+	if nil != err {
+		return nil, err
+	}
+	compression, err := fileCompression(fst, fileName, head[:n])
+	if nil != err || "" == compression {
+		return nil, err
+	}
+
+	start := time.Now()
+	compressed, err := io.ReadAll(file)
+	if nil != err {
+		return nil, err
+	}
+	return decompressFile(fst, compression, compressed, fileName, start)
+}
+
+// fileCompression returns the compression of the file, found from the magic bytes at the start when -compression
+// is auto. Empty when the file is not compressed, none reads the file as it is.
+func fileCompression(fst *common.FixedSizeTable, fileName string, head []byte) (string, error) {
+	if "none" == fst.Compression {
+		return "", nil
+	}
+	if "" == fst.Compression || "auto" == fst.Compression {
+		return common.DetectCompression(fileName, head)
+	}
+	return fst.Compression, nil
+}
+
+// decompressFile decompresses a whole file, the time spent since start is reported as decompress time
+func decompressFile(fst *common.FixedSizeTable, compression string, compressed []byte, fileName string, start time.Time) ([]byte, error) {
+	decompressed, err := common.Decompress(compression, compressed, fst.Cores)
+	if nil != err {
+		return nil, fmt.Errorf("%s: %v", fileName, err)
+	}
+	fst.Compression = compression
+	fst.CompressedSize = len(compressed)
+	fst.DurationDecompress = time.Since(start)
+	return decompressed, nil
+}
+
+// encodedQuotes returns the quote and escape of delimited input in the input encoding
+func encodedQuotes(fst *common.FixedSizeTable) (byte, byte, error) {
+	var encoded [2]byte
+	for i, c := range []byte{fst.Delimited.Quote, fst.Delimited.Escape} {
+		if 0 == c {
+			continue
+		}
+		e, err := common.EncodeTerminator(fst.InputEncoding, []byte{c})
+		if nil != err || 1 != len(e) {
+			return 0, 0, fmt.Errorf("quote or escape %q is not one byte in the input encoding", c)
+		}
+		encoded[i] = e[0]
+	}
+	return encoded[0], encoded[1], nil
+}
+
+// scanVariableRecords is a bufio.SplitFunc for variable records (RECFM=VB) where each record starts with a RDW,
+// when blocked the records are grouped in blocks starting with a BDW. Spanned records are not supported.
+func scanVariableRecords(blocked bool) bufio.SplitFunc {
+	blockLeft := 0
+
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if atEOF && 0 == len(data) {
+			return 0, nil, nil
+		}
+
+		// The BDW is consumed together with the first record, Scanner stops on an empty advance after EOF
+		offset := 0
+		left := blockLeft
+		if blocked && left <= 0 {
+			if len(data) < 4 {
+				return needMoreData(atEOF, "truncated block descriptor word")
+			}
+			blockLength := common.DescriptorLength(data)
+			if blockLength < 4 {
+				return 0, nil, fmt.Errorf("invalid block descriptor word %x", data[:4])
+			}
+			if 4 == blockLength {
+				return 4, nil, nil
+			}
+			left = blockLength - 4
+			offset = 4
+		}
+
+		record := data[offset:]
+		if len(record) < 4 {
+			return needMoreData(atEOF, "truncated record descriptor word")
+		}
+		recordLength := common.DescriptorLength(record)
+		if recordLength < 4 || record[0]&0x80 != 0 {
+			return 0, nil, fmt.Errorf("invalid record descriptor word %x", record[:4])
+		}
+		if len(record) < recordLength {
+			return needMoreData(atEOF, "truncated record")
+		}
+		blockLeft = left - recordLength
+		return offset + recordLength, record[4:recordLength], nil
+	}
+}
+
+func needMoreData(atEOF bool, message string) (int, []byte, error) {
+	if atEOF {
+		return 0, nil, errors.New(message)
+	}
+	return 0, nil, nil
+}
+
+// UnpackDecimal decodes a COMP-3 field, two digits per byte and the sign in the last nibble (D or B is negative).
+// Values of more than 18 digits that do not fit in a long are an error, see UnpackBigDecimal.
+func UnpackDecimal(packed string) (int64, error) {
+	var num int64
+
+	if 0 == len(packed) {
+		return 0, fmt.Errorf("empty packed decimal")
+	}
+
+	for i := 0; i < len(packed); i++ {
+		high := packed[i] >> 4
+		low := packed[i] & 0x0f
+
+		if high > 9 {
+			return 0, fmt.Errorf("invalid packed decimal digit %x", packed[i])
+		}
+		if num > (math.MaxInt64-int64(high))/10 {
+			return 0, fmt.Errorf("packed decimal %x does not fit in a long", packed)
+		}
+		num = num*10 + int64(high)
+
+		if i == len(packed)-1 {
+			switch low {
+			case 0x0d, 0x0b:
+				num = -num
+			case 0x0c, 0x0f, 0x0a, 0x0e:
+			default:
+				return 0, fmt.Errorf("invalid packed decimal sign %x", low)
+			}
+			break
+		}
+		if low > 9 {
+			return 0, fmt.Errorf("invalid packed decimal digit %x", packed[i])
+		}
+		if num > (math.MaxInt64-int64(low))/10 {
+			return 0, fmt.Errorf("packed decimal %x does not fit in a long", packed)
+		}
+		num = num*10 + int64(low)
+	}
+	return num, nil
+}
+
+// SetScaled sets rat to num with the implied scale, num / 10^scale. Scales over 18 take a big.Int denominator, 10^19 does not fit in a long.
+func SetScaled(rat *big.Rat, num int64, scale int) *big.Rat {
+	if scale <= 18 {
+		return rat.SetFrac64(num, int64(math.Pow10(scale)))
+	}
+	return rat.SetFrac(big.NewInt(num), new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil))
+}
+
+// checkPrecision is an error when num has more digits than precision, a precision of 0 is no limit
+func checkPrecision(num int64, precision int) error {
+	if precision <= 0 || precision > 18 {
+		return nil
+	}
+	limit := int64(math.Pow10(precision))
+	if num >= limit || num <= -limit {
+		return fmt.Errorf("%d exceeds precision %d", num, precision)
+	}
+	return nil
+}
+
+// UnpackBigDecimal decodes a COMP-3 field of any length into rat with the implied scale, zero on an error.
+// More digits than precision is an error. Fields of up to 9 bytes (17 digits) take the UnpackDecimal fast path.
+func UnpackBigDecimal(rat *big.Rat, packed string, precision int, scale int) error {
+	if len(packed) <= 9 {
+		num, err := UnpackDecimal(packed)
+		if nil == err {
+			err = checkPrecision(num, precision)
+		}
+		if nil != err {
+			num = 0
+		}
+		SetScaled(rat, num, scale)
+		return err
+	}
+
+	digits := make([]byte, 0, 2*len(packed))
+	for i := 0; i < len(packed); i++ {
+		high := packed[i] >> 4
+		low := packed[i] & 0x0f
+		if high > 9 || (low > 9 && i < len(packed)-1) {
+			rat.SetInt64(0)
+			return fmt.Errorf("invalid packed decimal digit %x", packed[i])
+		}
+		digits = append(digits, '0'+high)
+		if i < len(packed)-1 {
+			digits = append(digits, '0'+low)
+		}
+	}
+	if significant := strings.TrimLeft(string(digits), "0"); precision > 0 && len(significant) > precision {
+		rat.SetInt64(0)
+		return fmt.Errorf("packed decimal %x exceeds precision %d", packed, precision)
+	}
+	num, _ := new(big.Int).SetString(string(digits), 10)
+	switch packed[len(packed)-1] & 0x0f {
+	case 0x0d, 0x0b:
+		num.Neg(num)
+	case 0x0c, 0x0f, 0x0a, 0x0e:
+	default:
+		rat.SetInt64(0)
+		return fmt.Errorf("invalid packed decimal sign %x", packed[len(packed)-1]&0x0f)
+	}
+	rat.SetFrac(num, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil))
+	return nil
+}
+
+// ParseBinary decodes a big endian binary integer (COMP, COMP-4, COMP-5) of 1 to 8 bytes, two's complement when signed.
+func ParseBinary(value string, signed bool) (int64, error) {
+	if 0 == len(value) || len(value) > 8 {
+		return 0, fmt.Errorf("binary integer of %d bytes, 1 to 8 are supported", len(value))
+	}
+	var u uint64
+	for i := 0; i < len(value); i++ {
+		u = u<<8 | uint64(value[i])
+	}
+	if signed {
+		shift := 64 - 8*uint(len(value))
+		return int64(u<<shift) >> shift, nil
+	}
+	if u > math.MaxInt64 {
+		return 0, fmt.Errorf("unsigned binary integer %d does not fit in a long", u)
+	}
+	return int64(u), nil
+}
+
+// ParseBinaryFloat decodes a big endian float of 4 (COMP-1) or 8 (COMP-2) bytes. hfp is IBM hexadecimal floating point:
+// sign bit, 7 bit exponent of 16 in excess 64 and a 24 or 56 bit fraction. Otherwise IEEE 754.
+func ParseBinaryFloat(value string, hfp bool) (float64, error) {
+	if 4 != len(value) && 8 != len(value) {
+		return 0, fmt.Errorf("binary float of %d bytes, 4 or 8 are supported", len(value))
+	}
+	var u uint64
+	for i := 0; i < len(value); i++ {
+		u = u<<8 | uint64(value[i])
+	}
+	if !hfp {
+		if 4 == len(value) {
+			return float64(math.Float32frombits(uint32(u))), nil
+		}
+		return math.Float64frombits(u), nil
+	}
 
+	fractionBits := 8*len(value) - 8
+	fraction := u & (1<<uint(fractionBits) - 1)
+	exponent := int(u>>uint(fractionBits)) & 0x7f
+	f := math.Ldexp(float64(fraction), 4*(exponent-64)-fractionBits)
+	if 0 != u>>uint(8*len(value)-1) {
+		f = -f
 	}
+	return f, nil
 }
+
+// ParseZoned parses a zoned decimal with the sign as overpunch on the first or last digit, or as a separate +/- character.
+// The result is the unscaled number, ie 0001234J is -12341.
+func ParseZoned(zoned string, sign string, overpunch string) (int64, error) {
+	var negative bool
+	var signPos int
+	zoned = strings.TrimSpace(zoned)
+
+	if 0 == len(zoned) {
+		return 0, fmt.Errorf("empty zoned decimal")
+	}
+
+	switch sign {
+	case "trailing", "trailing-separate":
+		signPos = len(zoned) - 1
+	case "leading", "leading-separate":
+		signPos = 0
+	default:
+		return strconv.ParseInt(zoned, 10, 64)
+	}
+
+	digits := []byte(zoned)
+	switch sign {
+	case "trailing-separate", "leading-separate":
+		switch digits[signPos] {
+		case '-':
+			negative = true
+		case '+':
+		default:
+			return 0, fmt.Errorf("missing separate sign in %s", zoned)
+		}
+		digits = append(digits[:signPos], digits[signPos+1:]...)
+		signPos = -1
+	default:
+		var digit byte
+		var ok bool
+		digit, negative, ok = overpunchDigit(digits[signPos], overpunch)
+		if !ok {
+			return 0, fmt.Errorf("invalid overpunch in %s", zoned)
+		}
+		digits[signPos] = digit
+	}
+
+	var num int64
+	for _, d := range digits {
+		if d < '0' || d > '9' {
+			return 0, fmt.Errorf("invalid digit in zoned decimal %s", zoned)
+		}
+		if num > (math.MaxInt64-int64(d-'0'))/10 {
+			return 0, fmt.Errorf("zoned decimal %s does not fit in a long", zoned)
+		}
+		num = num*10 + int64(d-'0')
+	}
+	if negative {
+		num = -num
+	}
+	return num, nil
+}
+
+// overpunchDigit returns the digit and sign hidden in an overpunched character.
+func overpunchDigit(c byte, overpunch string) (byte, bool, bool) {
+	if "ascii" == overpunch {
+		switch {
+		case c >= '0' && c <= '9':
+			return c, false, true
+		case c >= 'p' && c <= 'y':
+			return c - 'p' + '0', true, true
+		}
+		return 0, false, false
+	}
+
+	switch {
+	case c == '{':
+		return '0', false, true
+	case c >= 'A' && c <= 'I':
+		return c - 'A' + '1', false, true
+	case c == '}':
+		return '0', true, true
+	case c >= 'J' && c <= 'R':
+		return c - 'J' + '1', true, true
+	case c >= '0' && c <= '9':
+		// Unsigned digit, some writers do not overpunch positive values
+		return c, false, true
+	}
+	return 0, false, false
+}
+
+// ParseDecimal sets rat from a number with explicit decimal point, or an integer with the implied scale.
+// Leading zeros do not count toward the precision. On an error rat is set to zero, it is reused for every row.
+func ParseDecimal(rat *big.Rat, decimal string, precision int, scale int) (err error) {
+	defer func() {
+		if nil != err {
+			rat.SetInt64(0)
+		}
+	}()
+	decimal = strings.TrimSpace(decimal)
+
+	digits := 0
+	significant := 0
+	for i := 0; i < len(decimal); i++ {
+		if decimal[i] >= '0' && decimal[i] <= '9' {
+			digits++
+			if 0 != significant || '0' != decimal[i] {
+				significant++
+			}
+		}
+	}
+	if 0 == digits {
+		return fmt.Errorf("no digits in decimal %s", decimal)
+	}
+	if precision > 0 && significant > precision {
+		return fmt.Errorf("decimal %s exceeds precision %d", decimal, precision)
+	}
+
+	if strings.ContainsRune(decimal, '.') {
+		if _, ok := rat.SetString(decimal); !ok {
+			return fmt.Errorf("invalid decimal %s", decimal)
+		}
+		return nil
+	}
+
+	// Fast path when the unscaled number fits in a long
+	if digits <= 18 {
+		num, err := strconv.ParseInt(decimal, 10, 64)
+		if nil != err {
+			return err
+		}
+		SetScaled(rat, num, scale)
+		return nil
+	}
+
+	num, ok := new(big.Int).SetString(decimal, 10)
+	if !ok {
+		return fmt.Errorf("invalid decimal %s", decimal)
+	}
+	rat.SetFrac(num, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil))
+	return nil
+}
+
 func PrintPerfomance(elapsed time.Duration, fst *common.FixedSizeTable) {
 
 	fcores := float64(fst.Cores)
-	var tpb = bytesize.New(float64(len(fst.Bytes)) / float64(elapsed.Seconds()))
+	size := len(fst.Bytes)
+	if nil == fst.Bytes {
+		size = fst.StreamedBytes
+	}
+	var tpb = bytesize.New(float64(size) / float64(elapsed.Seconds()))
 	var tpl = bytesize.New(float64(fst.LinesParsed) / float64(elapsed.Seconds()))
 	tpls := tpl.String()[:len(tpl.String())-1]
 	toAvro := fst.DurationToAvro.Seconds() / fcores
 	tpal := bytesize.New(float64(fst.LinesParsed) / toAvro)
 	tpals := tpal.String()[:len(tpal.String())-1]
 
-	fmt.Println("Time spend in total     :", elapsed, " parsing ", fst.LinesParsed, " lines from ", len(fst.Bytes), " bytes")
+	fmt.Println("Time spend in total     :", elapsed, " parsing ", fst.LinesParsed, " lines from ", size, " bytes")
+	if 0 != fst.CompressedSize {
+		fmt.Println("Time spent decompress   :", fst.DurationDecompress.Seconds(), "s", fst.Compression, "from", fst.CompressedSize, "bytes")
+	}
+	if "" != fst.Where {
+		fmt.Println("Lines filtered out      :", fst.LinesFiltered, " by where", fst.Where)
+	}
 
 	fmt.Println("Troughput bytes/s total :", tpb, "/s")
 	fmt.Println("Troughput lines/s total :", tpls, " Lines/s")
@@ -83,4 +625,44 @@ func PrintPerfomance(elapsed time.Duration, fst *common.FixedSizeTable) {
 	fmt.Println("Time spent toAvro       :", toAvro, "s")
 	fmt.Println("Time spent WaitDoneExport      :", fst.DurationDoneExport.Seconds(), "s")
 
+	for _, rt := range fst.RecordTypes {
+		if nil != rt.Filter {
+			fmt.Println("Lines of record type", rt.Code, ":", rt.LinesParsed, " filtered out:", rt.LinesFiltered)
+		} else {
+			fmt.Println("Lines of record type", rt.Code, ":", rt.LinesParsed)
+		}
+	}
+	codes := make([]string, 0, len(fst.UnknownTypes))
+	for code := range fst.UnknownTypes {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		fmt.Printf("Lines skipped of unknown record type %q : %d\n", code, fst.UnknownTypes[code])
+	}
+	if 0 != fst.ShortRecords {
+		fmt.Println("Lines skipped too short for the record type:", fst.ShortRecords)
+	}
+
+}
+
+// countValue reads the number of elements of OCCURS DEPENDING ON from its column
+func countValue(ff *common.FixedField, value string) (int, error) {
+	var num int64
+	var err error
+
+	switch {
+	case "comp-3" == ff.Usage:
+		num, err = UnpackDecimal(value)
+	case "comp" == ff.Usage:
+		num, err = ParseBinary(value, "signed" == ff.Sign)
+	case "" != ff.Sign:
+		num, err = ParseZoned(value, ff.Sign, ff.Overpunch)
+	default:
+		num, err = strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	}
+	if num < 0 {
+		num = 0
+	}
+	return int(num), err
 }
@@ -0,0 +1,163 @@
+/*
+ * MIT No Attribution
+ *
+ * Copyright 2021 Rickard Lundin (rickard@ignalina.dk)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of this
+ * software and associated documentation files (the "Software"), to deal in the Software
+ * without restriction, including without limitation the rights to use, copy, modify,
+ * merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+ * permit persons to whom the Software is furnished to do so.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+ * INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A
+ * PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+ * HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+ * OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+ * SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package fixed2avro
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestLargeScale(t *testing.T) {
+	want, _ := new(big.Rat).SetString("1.23e-18")
+	rat := new(big.Rat)
+	err := ParseDecimal(rat, "123", 0, 20)
+	if nil != err || 0 != rat.Cmp(want) {
+		t.Errorf("ParseDecimal 123 scale 20 is %v %v, want %v", rat, err, want)
+	}
+	if SetScaled(rat, 123, 20); 0 != rat.Cmp(want) {
+		t.Errorf("SetScaled 123 scale 20 is %v, want %v", rat, want)
+	}
+	err = UnpackBigDecimal(rat, "\x12\x3c", 0, 20)
+	if nil != err || 0 != rat.Cmp(want) {
+		t.Errorf("UnpackBigDecimal 123 scale 20 is %v %v, want %v", rat, err, want)
+	}
+	want.SetString("1.23e-16")
+	if SetScaled(rat, 123, 18); 0 != rat.Cmp(want) {
+		t.Errorf("SetScaled 123 scale 18 is %v, want %v", rat, want)
+	}
+}
+
+func TestUnpackBigDecimal(t *testing.T) {
+	tests := []struct {
+		packed    string
+		precision int
+		scale     int
+		want      string // empty when the value is an error
+	}{
+		{"\x12\x34\x5c", 5, 2, "123.45"},
+		{"\x12\x34\x5d", 5, 2, "-123.45"},
+		{"\x12\x34\x5b", 5, 0, "-12345"},
+		{"\x12\x34\x5f", 0, 0, "12345"},
+		{"\x00\x00\x1c", 1, 0, "1"},
+		{"\x99\x99\x9c", 4, 0, ""},
+		{"\x09\x99\x9c", 4, 0, "9999"},
+		{"\x99\x99\x9d", 4, 0, ""},
+		{"\x12\x34\x50", 5, 0, ""},
+		{"\x12\x34\x51", 5, 0, ""},
+		{"\x12\x34\x59", 5, 0, ""},
+		{"\x1a\x34\x5c", 5, 0, ""},
+		{"", 5, 0, ""},
+		{"\x01\x23\x45\x67\x89\x01\x23\x45\x67\x89\x1c", 20, 2, "123456789012345678.91"},
+		{"\x01\x23\x45\x67\x89\x01\x23\x45\x67\x89\x1d", 20, 0, "-12345678901234567891"},
+		{"\x01\x23\x45\x67\x89\x01\x23\x45\x67\x89\x1c", 19, 0, ""},
+		{"\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x1c", 1, 0, "1"},
+		{"\x01\x23\x45\x67\x89\x01\x23\x45\x67\x89\x12", 21, 0, ""},
+	}
+	for _, tt := range tests {
+		rat := new(big.Rat).SetInt64(7)
+		err := UnpackBigDecimal(rat, tt.packed, tt.precision, tt.scale)
+		if "" == tt.want {
+			if nil == err || 0 != rat.Sign() {
+				t.Errorf("%x precision %d unpacks to %s, want an error and zero", tt.packed, tt.precision, rat.FloatString(tt.scale))
+			}
+			continue
+		}
+		want, _ := new(big.Rat).SetString(tt.want)
+		if nil != err || 0 != rat.Cmp(want) {
+			t.Errorf("%x precision %d unpacks to %s %v, want %s", tt.packed, tt.precision, rat.FloatString(tt.scale), err, tt.want)
+		}
+	}
+}
+
+func TestParseZoned(t *testing.T) {
+	tests := []struct {
+		zoned     string
+		sign      string
+		overpunch string
+		want      int64
+		ok        bool
+	}{
+		{"0001234{", "trailing", "", 12340, true},
+		{"0001234A", "trailing", "", 12341, true},
+		{"0001234I", "trailing", "", 12349, true},
+		{"0001234}", "trailing", "", -12340, true},
+		{"0001234J", "trailing", "", -12341, true},
+		{"0001234R", "trailing", "", -12349, true},
+		{"00012345", "trailing", "", 12345, true},
+		{"J0001234", "leading", "", -10001234, true},
+		{"{0001234", "leading", "", 1234, true},
+		{"0001234p", "trailing", "ascii", -12340, true},
+		{"0001234y", "trailing", "ascii", -12349, true},
+		{"00012345", "trailing", "ascii", 12345, true},
+		{"q0001234", "leading", "ascii", -10001234, true},
+		{"0001234A", "trailing", "ascii", 0, false},
+		{"0001234{", "trailing", "ascii", 0, false},
+		{"0001234S", "trailing", "", 0, false},
+		{"00012-", "trailing-separate", "", -12, true},
+		{"00012+", "trailing-separate", "", 12, true},
+		{"-00012", "leading-separate", "", -12, true},
+		{"+00012", "leading-separate", "", 12, true},
+		{"000012", "leading-separate", "", 0, false},
+		{"00012 ", "trailing-separate", "", 0, false},
+		{"00X2J", "trailing", "", 0, false},
+		{"  12J", "trailing", "", -121, true},
+		{"", "trailing", "", 0, false},
+		{"-42", "", "", -42, true},
+		{"9223372036854775807{", "trailing", "", 0, false},
+		{"922337203685477580G", "trailing", "", 9223372036854775807, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseZoned(tt.zoned, tt.sign, tt.overpunch)
+		if tt.ok != (nil == err) || (tt.ok && got != tt.want) {
+			t.Errorf("ParseZoned(%q, %s, %s) is %d %v, want %d ok %v", tt.zoned, tt.sign, tt.overpunch, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseBinaryFloat(t *testing.T) {
+	tests := []struct {
+		value string
+		hfp   bool
+		want  float64
+		ok    bool
+	}{
+		{"\x41\x10\x00\x00", true, 1, true},
+		{"\xc1\x10\x00\x00", true, -1, true},
+		{"\x42\x64\x00\x00", true, 100, true},
+		{"\x40\x80\x00\x00", true, 0.5, true},
+		{"\xc0\x80\x00\x00", true, -0.5, true},
+		{"\x00\x00\x00\x00", true, 0, true},
+		{"\x80\x00\x00\x00", true, 0, true},
+		{"\x41\x10\x00\x00\x00\x00\x00\x00", true, 1, true},
+		{"\xc2\x76\xa0\x00\x00\x00\x00\x00", true, -118.625, true},
+		{"\x3f\x80\x00\x00", false, 1, true},
+		{"\xbf\x80\x00\x00", false, -1, true},
+		{"\x3f\xf0\x00\x00\x00\x00\x00\x00", false, 1, true},
+		{"\x00\x00\x00\x00\x00\x00\x00\x00", false, 0, true},
+		{"\x41\x10\x00", true, 0, false},
+		{"", false, 0, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseBinaryFloat(tt.value, tt.hfp)
+		if tt.ok != (nil == err) || (tt.ok && got != tt.want) {
+			t.Errorf("ParseBinaryFloat(%x, hfp %v) is %v %v, want %v ok %v", tt.value, tt.hfp, got, err, tt.want, tt.ok)
+		}
+	}
+}
@@ -8,8 +8,11 @@ require (
 	github.com/confluentinc/confluent-kafka-go v1.7.0
 	github.com/hamba/avro v1.6.0
 	github.com/inhies/go-bytesize v0.0.0-20210819104631-275770b98743
+	github.com/klauspost/compress v1.15.15
 	github.com/landoop/schema-registry v0.0.0-20190327143759-50a5701c1891
 	github.com/pkg/errors v0.9.1
+	github.com/ulikunitz/xz v0.5.11
+	golang.org/x/text v0.3.7
 )
 
 require (